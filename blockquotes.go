@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+)
+
+// parseBlockquotes returns every top level blockquote as a single item
+func parseBlockquotes(doc *Document) []ContentItem {
+	var blockquotes []ContentItem
+	for _, block := range doc.Root.Children {
+		if block.Type == BLOCK_QUOTE {
+			blockquotes = append(blockquotes, newBlockquoteItem(doc.Lines[block.StartLine:block.EndLine+1], block.StartLine))
+		}
+	}
+	return blockquotes
+}
+
+// parseCallouts returns the blockquotes that are Obsidian/GitHub style
+// callouts (e.g. "> [!WARNING] Title")
+func parseCallouts(doc *Document) []ContentItem {
+	var callouts []ContentItem
+	for _, blockquote := range parseBlockquotes(doc) {
+		if _, ok := blockquote.Fields["callout.type"]; ok {
+			callouts = append(callouts, blockquote)
+		}
+	}
+	return callouts
+}
+
+func newBlockquoteItem(lines []string, start int) ContentItem {
+	item := ContentItem{Text: strings.Join(lines, "\n"), Line: start, EndLine: start + len(lines) - 1}
+
+	calloutType, fold, title, ok := parseCalloutHeader(stripBlockquoteMarker(lines[0]))
+	if ok {
+		item.Fields = Metadata{
+			"callout.type":  calloutType,
+			"callout.title": title,
+			"callout.fold":  fold,
+		}
+	}
+
+	return item
+}
+
+// stripBlockquoteMarker removes the leading ">" (and the optional space after
+// it) from a blockquote line
+func stripBlockquoteMarker(line string) string {
+	line = strings.TrimLeft(line, " ")
+	line = strings.TrimPrefix(line, ">")
+	return strings.TrimPrefix(line, " ")
+}
+
+// parseCalloutHeader parses the first line of a callout, e.g.
+// "[!NOTE]- Title" -> "note", "closed", "Title". The fold state is "open"
+// for "+", "closed" for "-" and "none" if the callout isn't foldable. If no
+// title is given, the capitalized callout type is used like Obsidian does.
+func parseCalloutHeader(line string) (string, string, string, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "[!") {
+		return "", "", "", false
+	}
+
+	end := strings.Index(line, "]")
+	if end == -1 || end == 2 {
+		return "", "", "", false
+	}
+
+	calloutType := strings.ToLower(line[2:end])
+	rest := line[end+1:]
+
+	fold := "none"
+	if strings.HasPrefix(rest, "+") {
+		fold = "open"
+		rest = rest[1:]
+	} else if strings.HasPrefix(rest, "-") {
+		fold = "closed"
+		rest = rest[1:]
+	}
+
+	title := strings.TrimSpace(rest)
+	if title == "" {
+		title = strings.ToUpper(calloutType[:1]) + calloutType[1:]
+	}
+
+	return calloutType, fold, title, true
+}
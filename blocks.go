@@ -0,0 +1,837 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// This file implements the block level part of the CommonMark spec
+// (https://spec.commonmark.org/). A markdown document is parsed line by line
+// into a tree of blocks (paragraphs, headings, lists, ...) which every query
+// type then reads its results from. Inline content (emphasis, links, ...) is
+// left as text.
+
+type BlockType int
+
+const (
+	BLOCK_DOCUMENT BlockType = iota
+	BLOCK_PARAGRAPH
+	BLOCK_HEADING
+	BLOCK_THEMATIC_BREAK
+	BLOCK_CODE
+	BLOCK_HTML
+	BLOCK_QUOTE
+	BLOCK_LIST
+	BLOCK_LIST_ITEM
+	BLOCK_TABLE // GFM pipe table
+)
+
+var BlockTypeNames = map[BlockType]string{
+	BLOCK_DOCUMENT:       "document",
+	BLOCK_PARAGRAPH:      "paragraph",
+	BLOCK_HEADING:        "heading",
+	BLOCK_THEMATIC_BREAK: "thematic_break",
+	BLOCK_CODE:           "code_block",
+	BLOCK_HTML:           "html_block",
+	BLOCK_QUOTE:          "block_quote",
+	BLOCK_LIST:           "list",
+	BLOCK_LIST_ITEM:      "item",
+	BLOCK_TABLE:          "table",
+}
+
+func (t BlockType) String() string {
+	return BlockTypeNames[t]
+}
+
+// Block is a node of the document tree
+type Block struct {
+	Type      BlockType
+	Parent    *Block
+	Children  []*Block
+	StartLine int // Index of the first source line of the block
+	EndLine   int // Index of the last source line of the block
+
+	// Content holds the lines of leaf blocks with the markers of their
+	// containers (e.g. "> " or the list item indentation) removed
+	Content []string
+
+	Level int        // Heading level
+	Text  string     // Heading text
+	Fence *CodeFence // Opening fence of fenced code blocks (nil for indented code)
+	List  *ListData  // List and list item data
+
+	open          bool
+	htmlBlockType int
+}
+
+// ListData describes the marker of a list item (and the list it belongs to)
+type ListData struct {
+	Ordered      bool
+	BulletChar   byte // '-', '+' or '*' for bullet lists
+	Delimiter    byte // '.' or ')' for ordered lists
+	Start        int
+	Tight        bool
+	MarkerOffset int
+	Padding      int
+}
+
+// LinkReference is a link reference definition ([label]: target "title")
+type LinkReference struct {
+	Target string
+	Title  string
+}
+
+// Document is a parsed markdown document
+type Document struct {
+	Root       *Block
+	Lines      []string
+	References map[string]LinkReference
+}
+
+const codeIndent = 4
+
+var (
+	reMaybeSpecial        = regexp.MustCompile(`^[#` + "`" + `~*+_=<>0-9-]`)
+	reATXHeadingMarker    = regexp.MustCompile(`^#{1,6}(?:[ \t]+|$)`)
+	reCodeFence           = regexp.MustCompile("^`{3,}[^`]*$|^~{3,}")
+	reClosingCodeFence    = regexp.MustCompile("^(?:`{3,}|~{3,})[ \t]*$")
+	reSetextHeadingLine   = regexp.MustCompile(`^(?:=+|-+)[ \t]*$`)
+	reThematicBreak       = regexp.MustCompile(`^(?:\*[ \t]*){3,}$|^(?:_[ \t]*){3,}$|^(?:-[ \t]*){3,}$`)
+	reBulletListMarker    = regexp.MustCompile(`^[*+-]`)
+	reOrderedListMarker   = regexp.MustCompile(`^(\d{1,9})([.)])`)
+	reATXClosingSequence  = regexp.MustCompile(`[ \t]+#+[ \t]*$`)
+	reATXOnlyClosing      = regexp.MustCompile(`^[ \t]*#+[ \t]*$`)
+	reLinkReferenceOpener = regexp.MustCompile(`^[ \t]*\[`)
+)
+
+var (
+	htmlTagName       = `[A-Za-z][A-Za-z0-9-]*`
+	htmlAttributeName = `[a-zA-Z_:][a-zA-Z0-9:._-]*`
+	htmlAttribute     = `(?:\s+` + htmlAttributeName + `(?:\s*=\s*(?:[^"'=<>` + "`" + `\x00-\x20]+|'[^']*'|"[^"]*"))?)`
+	htmlOpenTag       = `<` + htmlTagName + htmlAttribute + `*\s*/?>`
+	htmlCloseTag      = `</` + htmlTagName + `\s*[>]`
+
+	reHTMLBlockOpen = []*regexp.Regexp{
+		nil,
+		regexp.MustCompile(`(?i)^<(?:script|pre|textarea|style)(?:\s|>|$)`),
+		regexp.MustCompile(`^<!--`),
+		regexp.MustCompile(`^<[?]`),
+		regexp.MustCompile(`^<![A-Za-z]`),
+		regexp.MustCompile(`^<!\[CDATA\[`),
+		regexp.MustCompile(`(?i)^<[/]?(?:address|article|aside|base|basefont|blockquote|body|caption|center|col|colgroup|dd|details|dialog|dir|div|dl|dt|fieldset|figcaption|figure|footer|form|frame|frameset|h[123456]|head|header|hr|html|iframe|legend|li|link|main|menu|menuitem|nav|noframes|ol|optgroup|option|p|param|search|section|summary|table|tbody|td|tfoot|th|thead|title|tr|track|ul)(?:\s|[/]?[>]|$)`),
+		regexp.MustCompile(`(?i)^(?:` + htmlOpenTag + `|` + htmlCloseTag + `)\s*$`),
+	}
+
+	reHTMLBlockClose = []*regexp.Regexp{
+		nil,
+		regexp.MustCompile(`(?i)</(?:script|pre|textarea|style)>`),
+		regexp.MustCompile(`-->`),
+		regexp.MustCompile(`\?>`),
+		regexp.MustCompile(`>`),
+		regexp.MustCompile(`\]\]>`),
+	}
+)
+
+// blockParser holds the state of the parser while going through the lines
+type blockParser struct {
+	doc                  *Document
+	tip                  *Block
+	oldTip               *Block
+	lastMatchedContainer *Block
+	allClosed            bool
+
+	lineNumber           int
+	currentLine          string
+	offset               int
+	column               int
+	nextNonspace         int
+	nextNonspaceColumn   int
+	indent               int
+	indented             bool
+	blank                bool
+	partiallyConsumedTab bool
+}
+
+// parseDocument parses the lines of a markdown document into a block tree
+func parseDocument(lines []string) *Document {
+	root := &Block{Type: BLOCK_DOCUMENT, open: true}
+	p := &blockParser{
+		doc: &Document{
+			Root:       root,
+			Lines:      lines,
+			References: make(map[string]LinkReference),
+		},
+		tip:       root,
+		oldTip:    root,
+		allClosed: true,
+	}
+
+	for i, line := range lines {
+		p.lineNumber = i
+		p.incorporateLine(line)
+	}
+
+	for p.tip != nil {
+		p.finalize(p.tip, len(lines)-1)
+	}
+
+	return p.doc
+}
+
+func (p *blockParser) incorporateLine(line string) {
+	container := p.doc.Root
+	p.oldTip = p.tip
+	p.offset = 0
+	p.column = 0
+	p.blank = false
+	p.partiallyConsumedTab = false
+	p.currentLine = strings.ReplaceAll(line, "\x00", "�")
+
+	// Try to match the open containers (block quotes, list items, ...) first.
+	// Each one that matches consumes its marker from the start of the line.
+	allMatched := true
+	for len(container.Children) > 0 {
+		lastChild := container.Children[len(container.Children)-1]
+		if !lastChild.open {
+			break
+		}
+		container = lastChild
+		p.findNextNonspace()
+
+		switch p.continueBlock(container) {
+		case 0: // Matched, keep going
+		case 1: // Failed to match
+			allMatched = false
+		case 2: // Reached the end of a fenced code block, the line is consumed
+			return
+		}
+
+		if !allMatched {
+			container = container.Parent
+			break
+		}
+	}
+
+	p.allClosed = container == p.oldTip
+	p.lastMatchedContainer = container
+
+	// Try new block starts, unless the last matched container is a code or
+	// HTML block that takes the rest of the line as content
+	matchedLeaf := container.Type != BLOCK_PARAGRAPH && acceptsLines(container.Type)
+	for !matchedLeaf {
+		p.findNextNonspace()
+
+		if !p.indented && !reMaybeSpecial.MatchString(p.currentLine[p.nextNonspace:]) {
+			p.advanceNextNonspace()
+			break
+		}
+
+		result := p.tryBlockStarts(container)
+		if result == 0 {
+			p.advanceNextNonspace()
+			break
+		}
+		container = p.tip
+		if result == 2 {
+			matchedLeaf = true
+		}
+	}
+
+	// What remains of the line is text. Check for lazy paragraph continuation
+	// lines first, then add the text to the right container.
+	if !p.allClosed && !p.blank && p.tip.Type == BLOCK_PARAGRAPH {
+		p.addLine()
+		return
+	}
+
+	p.closeUnmatchedBlocks()
+
+	if acceptsLines(container.Type) {
+		p.addLine()
+		if container.Type == BLOCK_HTML && container.htmlBlockType >= 1 && container.htmlBlockType <= 5 &&
+			reHTMLBlockClose[container.htmlBlockType].MatchString(p.currentLine[p.offset:]) {
+			p.finalize(container, p.lineNumber)
+		}
+	} else if p.offset < len(p.currentLine) && !p.blank {
+		p.addChild(BLOCK_PARAGRAPH)
+		p.advanceNextNonspace()
+		p.addLine()
+	}
+}
+
+// continueBlock checks if an open block continues on the current line.
+// Returns 0 if it does, 1 if it doesn't and 2 if the line was consumed.
+func (p *blockParser) continueBlock(block *Block) int {
+	switch block.Type {
+	case BLOCK_QUOTE:
+		if !p.indented && p.peek(p.nextNonspace) == '>' {
+			p.advanceNextNonspace()
+			p.advanceOffset(1, false)
+			if isSpaceOrTab(p.peek(p.offset)) {
+				p.advanceOffset(1, true)
+			}
+			return 0
+		}
+		return 1
+
+	case BLOCK_LIST_ITEM:
+		if p.blank {
+			// An item can begin with at most one blank line
+			if len(block.Children) == 0 {
+				return 1
+			}
+			p.advanceNextNonspace()
+		} else if p.indent >= block.List.MarkerOffset+block.List.Padding {
+			p.advanceOffset(block.List.MarkerOffset+block.List.Padding, true)
+		} else {
+			return 1
+		}
+		return 0
+
+	case BLOCK_CODE:
+		if block.Fence != nil {
+			rest := p.currentLine[p.nextNonspace:]
+			if p.indent <= 3 && len(rest) > 0 && rest[0] == block.Fence.Char && reClosingCodeFence.MatchString(rest) {
+				fenceLength := len(rest) - len(strings.TrimLeft(rest, string(block.Fence.Char)))
+				if fenceLength >= block.Fence.Length {
+					p.finalize(block, p.lineNumber)
+					return 2
+				}
+			}
+			// Skip the optional indentation of the fence
+			for i := block.Fence.Indent; i > 0 && isSpaceOrTab(p.peek(p.offset)); i-- {
+				p.advanceOffset(1, true)
+			}
+			return 0
+		}
+
+		if p.indent >= codeIndent {
+			p.advanceOffset(codeIndent, true)
+		} else if p.blank {
+			p.advanceNextNonspace()
+		} else {
+			return 1
+		}
+		return 0
+
+	case BLOCK_HTML:
+		if p.blank && (block.htmlBlockType == 6 || block.htmlBlockType == 7) {
+			return 1
+		}
+		return 0
+
+	case BLOCK_PARAGRAPH:
+		if p.blank {
+			return 1
+		}
+		return 0
+
+	case BLOCK_LIST, BLOCK_DOCUMENT:
+		return 0
+	}
+
+	// Headings and thematic breaks only take one line
+	return 1
+}
+
+// tryBlockStarts tries to start a new block on the current line. Returns 0
+// if nothing matched, 1 for container blocks and 2 for leaf blocks.
+func (p *blockParser) tryBlockStarts(container *Block) int {
+	rest := p.currentLine[p.nextNonspace:]
+
+	// Block quote
+	if !p.indented && p.peek(p.nextNonspace) == '>' {
+		p.advanceNextNonspace()
+		p.advanceOffset(1, false)
+		if isSpaceOrTab(p.peek(p.offset)) {
+			p.advanceOffset(1, true)
+		}
+		p.closeUnmatchedBlocks()
+		p.addChild(BLOCK_QUOTE)
+		return 1
+	}
+
+	// ATX heading
+	if match := reATXHeadingMarker.FindString(rest); !p.indented && match != "" {
+		p.advanceNextNonspace()
+		p.advanceOffset(len(match), false)
+		p.closeUnmatchedBlocks()
+		heading := p.addChild(BLOCK_HEADING)
+		heading.Level = len(strings.TrimSpace(match))
+		text := p.currentLine[p.offset:]
+		text = reATXOnlyClosing.ReplaceAllString(text, "")
+		text = reATXClosingSequence.ReplaceAllString(text, "")
+		heading.Text = strings.TrimSpace(text)
+		heading.Content = []string{heading.Text}
+		p.advanceOffset(len(p.currentLine)-p.offset, false)
+		return 2
+	}
+
+	// Fenced code block
+	if match := reCodeFence.FindString(rest); !p.indented && match != "" {
+		char := match[0]
+		fenceLength := len(match) - len(strings.TrimLeft(match, string(char)))
+		p.closeUnmatchedBlocks()
+		code := p.addChild(BLOCK_CODE)
+		code.Fence = &CodeFence{Char: char, Length: fenceLength, Indent: p.indent}
+		p.advanceNextNonspace()
+		p.advanceOffset(fenceLength, false)
+		return 2
+	}
+
+	// HTML block
+	if !p.indented && p.peek(p.nextNonspace) == '<' {
+		for blockType := 1; blockType <= 7; blockType++ {
+			if reHTMLBlockOpen[blockType].MatchString(rest) &&
+				(blockType < 7 || (container.Type != BLOCK_PARAGRAPH && !(!p.allClosed && !p.blank && p.tip.Type == BLOCK_PARAGRAPH))) {
+				p.closeUnmatchedBlocks()
+				html := p.addChild(BLOCK_HTML)
+				html.htmlBlockType = blockType
+				return 2
+			}
+		}
+	}
+
+	// Setext heading
+	if !p.indented && container.Type == BLOCK_PARAGRAPH && reSetextHeadingLine.MatchString(rest) {
+		p.closeUnmatchedBlocks()
+		p.extractLinkReferences(container)
+		if len(container.Content) > 0 {
+			var textLines []string
+			for _, line := range container.Content {
+				textLines = append(textLines, strings.TrimSpace(line))
+			}
+			container.Type = BLOCK_HEADING
+			container.Text = strings.Join(textLines, " ")
+			if rest[0] == '=' {
+				container.Level = 1
+			} else {
+				container.Level = 2
+			}
+			p.advanceOffset(len(p.currentLine)-p.offset, false)
+			return 2
+		}
+		return 0
+	}
+
+	// Thematic break
+	if !p.indented && reThematicBreak.MatchString(rest) {
+		p.closeUnmatchedBlocks()
+		p.addChild(BLOCK_THEMATIC_BREAK)
+		p.advanceOffset(len(p.currentLine)-p.offset, false)
+		return 2
+	}
+
+	// List item
+	if !p.indented || container.Type == BLOCK_LIST {
+		if data := p.parseListMarker(container); data != nil {
+			p.closeUnmatchedBlocks()
+			if p.tip.Type != BLOCK_LIST || !listsMatch(p.tip.List, data) {
+				list := p.addChild(BLOCK_LIST)
+				listData := *data
+				listData.Tight = true
+				list.List = &listData
+			}
+			item := p.addChild(BLOCK_LIST_ITEM)
+			item.List = data
+			return 1
+		}
+	}
+
+	// Indented code block
+	if p.indented && p.tip.Type != BLOCK_PARAGRAPH && !p.blank {
+		p.advanceOffset(codeIndent, true)
+		p.closeUnmatchedBlocks()
+		p.addChild(BLOCK_CODE)
+		return 2
+	}
+
+	return 0
+}
+
+// parseListMarker parses a list marker ("-", "*", "+", "1." or "1)") and
+// consumes it together with the spaces after it
+func (p *blockParser) parseListMarker(container *Block) *ListData {
+	if p.indent >= codeIndent {
+		return nil
+	}
+
+	rest := p.currentLine[p.nextNonspace:]
+	data := &ListData{MarkerOffset: p.indent}
+	var markerLength int
+
+	if match := reBulletListMarker.FindString(rest); match != "" {
+		data.BulletChar = match[0]
+		markerLength = len(match)
+	} else if match := reOrderedListMarker.FindStringSubmatch(rest); match != nil &&
+		(container.Type != BLOCK_PARAGRAPH || match[1] == "1") {
+		data.Ordered = true
+		data.Start, _ = strconv.Atoi(match[1])
+		data.Delimiter = match[2][0]
+		markerLength = len(match[0])
+	} else {
+		return nil
+	}
+
+	// The marker has to be followed by a space, a tab or the end of the line
+	next := p.peek(p.nextNonspace + markerLength)
+	if next != 0 && next != ' ' && next != '\t' {
+		return nil
+	}
+
+	// An empty list item can't interrupt a paragraph
+	if container.Type == BLOCK_PARAGRAPH && strings.TrimSpace(p.currentLine[p.nextNonspace+markerLength:]) == "" {
+		return nil
+	}
+
+	p.advanceNextNonspace()
+	p.advanceOffset(markerLength, true)
+	spacesStartColumn := p.column
+	spacesStartOffset := p.offset
+	for {
+		p.advanceOffset(1, true)
+		if p.column-spacesStartColumn >= 5 || !isSpaceOrTab(p.peek(p.offset)) {
+			break
+		}
+	}
+
+	blankItem := p.offset >= len(p.currentLine)
+	spacesAfterMarker := p.column - spacesStartColumn
+	if spacesAfterMarker >= 5 || spacesAfterMarker < 1 || blankItem {
+		// The content starts right after the marker and a single space, the
+		// rest of the spaces are part of the content (e.g. indented code)
+		data.Padding = markerLength + 1
+		p.column = spacesStartColumn
+		p.offset = spacesStartOffset
+		if isSpaceOrTab(p.peek(p.offset)) {
+			p.advanceOffset(1, true)
+		}
+	} else {
+		data.Padding = markerLength + spacesAfterMarker
+	}
+
+	return data
+}
+
+func listsMatch(list *ListData, item *ListData) bool {
+	return list.Ordered == item.Ordered && list.Delimiter == item.Delimiter && list.BulletChar == item.BulletChar
+}
+
+func acceptsLines(blockType BlockType) bool {
+	return blockType == BLOCK_PARAGRAPH || blockType == BLOCK_CODE || blockType == BLOCK_HTML
+}
+
+func canContain(parent BlockType, child BlockType) bool {
+	switch parent {
+	case BLOCK_DOCUMENT, BLOCK_QUOTE, BLOCK_LIST_ITEM:
+		return child != BLOCK_LIST_ITEM
+	case BLOCK_LIST:
+		return child == BLOCK_LIST_ITEM
+	}
+	return false
+}
+
+func (p *blockParser) addChild(blockType BlockType) *Block {
+	for !canContain(p.tip.Type, blockType) {
+		p.finalize(p.tip, p.lineNumber-1)
+	}
+
+	block := &Block{
+		Type:      blockType,
+		Parent:    p.tip,
+		StartLine: p.lineNumber,
+		EndLine:   p.lineNumber,
+		open:      true,
+	}
+	p.tip.Children = append(p.tip.Children, block)
+	p.tip = block
+	return block
+}
+
+func (p *blockParser) addLine() {
+	line := p.currentLine[p.offset:]
+	if p.partiallyConsumedTab {
+		// Replace the rest of the tab with spaces
+		line = strings.Repeat(" ", 4-p.column%4) + p.currentLine[p.offset+1:]
+	}
+	p.tip.Content = append(p.tip.Content, line)
+}
+
+func (p *blockParser) closeUnmatchedBlocks() {
+	if p.allClosed {
+		return
+	}
+	for p.oldTip != p.lastMatchedContainer {
+		parent := p.oldTip.Parent
+		p.finalize(p.oldTip, p.lineNumber-1)
+		p.oldTip = parent
+	}
+	p.allClosed = true
+}
+
+func (p *blockParser) finalize(block *Block, lineNumber int) {
+	block.open = false
+	block.EndLine = max(lineNumber, block.StartLine)
+
+	switch block.Type {
+	case BLOCK_PARAGRAPH:
+		p.extractLinkReferences(block)
+		if len(block.Content) == 0 {
+			removeBlock(block)
+		} else {
+			splitTable(block)
+		}
+
+	case BLOCK_CODE:
+		if block.Fence != nil {
+			// The first line is the info string
+			block.Fence.Info = strings.TrimSpace(unescapeString(block.Content[0]))
+			block.Content = block.Content[1:]
+		} else {
+			for len(block.Content) > 0 && strings.TrimSpace(block.Content[len(block.Content)-1]) == "" {
+				block.Content = block.Content[:len(block.Content)-1]
+				block.EndLine--
+			}
+		}
+
+	case BLOCK_LIST_ITEM:
+		if len(block.Children) > 0 {
+			block.EndLine = block.Children[len(block.Children)-1].EndLine
+		} else {
+			block.EndLine = block.StartLine
+		}
+
+	case BLOCK_LIST:
+		block.EndLine = block.Children[len(block.Children)-1].EndLine
+		for i, item := range block.Children {
+			if endsWithBlankLine(item, block.Children, i) {
+				block.List.Tight = false
+				break
+			}
+			for j := range item.Children {
+				if endsWithBlankLine(item.Children[j], item.Children, j) {
+					block.List.Tight = false
+					break
+				}
+			}
+			if !block.List.Tight {
+				break
+			}
+		}
+	}
+
+	p.tip = block.Parent
+}
+
+// endsWithBlankLine checks if there's a blank line between the block and the
+// next one
+func endsWithBlankLine(block *Block, siblings []*Block, index int) bool {
+	return index+1 < len(siblings) && block.EndLine != siblings[index+1].StartLine-1
+}
+
+// extractLinkReferences removes the link reference definitions from the
+// start of a paragraph and stores them in the document
+func (p *blockParser) extractLinkReferences(block *Block) {
+	for len(block.Content) > 0 && reLinkReferenceOpener.MatchString(block.Content[0]) {
+		label, reference, consumed, ok := parseLinkReference(block.Content)
+		if !ok {
+			break
+		}
+		if _, exists := p.doc.References[label]; !exists {
+			p.doc.References[label] = reference
+		}
+		block.Content = block.Content[consumed:]
+		block.StartLine += consumed
+	}
+}
+
+var reLinkReference = regexp.MustCompile(`^[ \t]*\[((?:[^\\\[\]]|\\.){1,999})\]:[ \t]*(?:\n[ \t]*)?(<(?:[^<>\n\\]|\\.)*>|[^<\s\x00-\x1f][^\s\x00-\x1f]*)(?:([ \t]+|[ \t]*\n[ \t]*)("(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'|\((?:[^()\\]|\\.)*\)))?[ \t]*(?:\n|$)`)
+
+// parseLinkReference parses a link reference definition from the start of
+// the lines. Returns the normalized label, the reference and the number of
+// lines it takes up.
+func parseLinkReference(lines []string) (string, LinkReference, int, bool) {
+	text := strings.Join(lines, "\n")
+	match := reLinkReference.FindStringSubmatchIndex(text)
+	if match == nil {
+		return "", LinkReference{}, 0, false
+	}
+
+	label := normalizeReferenceLabel(text[match[2]:match[3]])
+	if label == "" {
+		return "", LinkReference{}, 0, false
+	}
+
+	target := text[match[4]:match[5]]
+	if strings.HasPrefix(target, "<") {
+		target = target[1 : len(target)-1]
+	}
+
+	title := ""
+	end := match[1]
+	if match[8] != -1 {
+		title = text[match[8]+1 : match[9]-1]
+	}
+
+	consumed := strings.Count(strings.TrimSuffix(text[:end], "\n"), "\n") + 1
+	return label, LinkReference{Target: unescapeString(target), Title: unescapeString(title)}, consumed, true
+}
+
+// splitTable turns a paragraph that contains a GFM table (a header row
+// followed by a delimiter row) into a paragraph with the lines before the
+// table and a table block
+func splitTable(block *Block) {
+	for i := 0; i+1 < len(block.Content); i++ {
+		if !strings.Contains(block.Content[i], "|") && !strings.Contains(block.Content[i+1], "|") {
+			continue
+		}
+		header := splitTableRow(block.Content[i])
+		alignments, ok := parseTableDelimiterRow(block.Content[i+1])
+		if !ok || len(alignments) != len(header) {
+			continue
+		}
+
+		if i == 0 {
+			block.Type = BLOCK_TABLE
+			return
+		}
+
+		table := &Block{
+			Type:      BLOCK_TABLE,
+			Parent:    block.Parent,
+			StartLine: block.StartLine + i,
+			EndLine:   block.EndLine,
+			Content:   block.Content[i:],
+		}
+		block.Content = block.Content[:i]
+		block.EndLine = table.StartLine - 1
+
+		siblings := block.Parent.Children
+		for index, sibling := range siblings {
+			if sibling == block {
+				siblings = append(siblings[:index+1], append([]*Block{table}, siblings[index+1:]...)...)
+				break
+			}
+		}
+		block.Parent.Children = siblings
+		return
+	}
+}
+
+func removeBlock(block *Block) {
+	siblings := block.Parent.Children
+	for i, sibling := range siblings {
+		if sibling == block {
+			block.Parent.Children = append(siblings[:i], siblings[i+1:]...)
+			return
+		}
+	}
+}
+
+// findNextNonspace finds the next non-space character of the current line
+// and computes the indentation up to it (tabs expand to the next multiple of 4)
+func (p *blockParser) findNextNonspace() {
+	i := p.offset
+	columns := p.column
+	for i < len(p.currentLine) {
+		if p.currentLine[i] == ' ' {
+			i++
+			columns++
+		} else if p.currentLine[i] == '\t' {
+			i++
+			columns += 4 - columns%4
+		} else {
+			break
+		}
+	}
+
+	p.blank = i >= len(p.currentLine)
+	p.nextNonspace = i
+	p.nextNonspaceColumn = columns
+	p.indent = columns - p.column
+	p.indented = p.indent >= codeIndent
+}
+
+func (p *blockParser) advanceNextNonspace() {
+	p.offset = p.nextNonspace
+	p.column = p.nextNonspaceColumn
+	p.partiallyConsumedTab = false
+}
+
+// advanceOffset moves forward by count characters, or by count columns if
+// columns is set, in which case tabs can be partially consumed
+func (p *blockParser) advanceOffset(count int, columns bool) {
+	for count > 0 && p.offset < len(p.currentLine) {
+		if p.currentLine[p.offset] == '\t' {
+			charsToTab := 4 - p.column%4
+			if columns {
+				p.partiallyConsumedTab = charsToTab > count
+				charsToAdvance := min(charsToTab, count)
+				p.column += charsToAdvance
+				if !p.partiallyConsumedTab {
+					p.offset++
+				}
+				count -= charsToAdvance
+			} else {
+				p.partiallyConsumedTab = false
+				p.column += charsToTab
+				p.offset++
+				count--
+			}
+		} else {
+			p.partiallyConsumedTab = false
+			p.offset++
+			p.column++
+			count--
+		}
+	}
+}
+
+func (p *blockParser) peek(index int) byte {
+	if index < len(p.currentLine) {
+		return p.currentLine[index]
+	}
+	return 0
+}
+
+func isSpaceOrTab(char byte) bool {
+	return char == ' ' || char == '\t'
+}
+
+// unescapeString removes backslash escapes from punctuation characters
+func unescapeString(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+
+	var result strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", s[i+1]) != -1 {
+			i++
+		}
+		result.WriteByte(s[i])
+	}
+	return result.String()
+}
+
+// walkBlocks calls fn for every block in the tree in document order. If fn
+// returns false, the children of the block are skipped.
+func walkBlocks(block *Block, fn func(*Block) bool) {
+	if !fn(block) {
+		return
+	}
+	for _, child := range block.Children {
+		walkBlocks(child, fn)
+	}
+}
+
+// hasAncestor checks if the block is nested inside a block of the given type
+func (b *Block) hasAncestor(blockType BlockType) bool {
+	for parent := b.Parent; parent != nil; parent = parent.Parent {
+		if parent.Type == blockType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,949 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// dumpBlocks prints the block tree in an indented form that is easy to
+// compare, e.g. `list (bullet, tight)` followed by its items
+func dumpBlocks(block *Block, depth int, out *strings.Builder) {
+	out.WriteString(strings.Repeat("  ", depth) + block.Type.String())
+
+	switch block.Type {
+	case BLOCK_PARAGRAPH:
+		var lines []string
+		for _, line := range block.Content {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+		fmt.Fprintf(out, " %q", strings.Join(lines, "\n"))
+	case BLOCK_HEADING:
+		fmt.Fprintf(out, " %d %q", block.Level, block.Text)
+	case BLOCK_CODE:
+		if block.Fence != nil {
+			fmt.Fprintf(out, " info=%q", block.Fence.Info)
+		}
+		fmt.Fprintf(out, " %q", strings.Join(block.Content, "\n"))
+	case BLOCK_HTML, BLOCK_TABLE:
+		fmt.Fprintf(out, " %q", strings.Join(block.Content, "\n"))
+	case BLOCK_LIST:
+		kind := "bullet"
+		if block.List.Ordered {
+			kind = fmt.Sprintf("ordered %d", block.List.Start)
+		}
+		spacing := "tight"
+		if !block.List.Tight {
+			spacing = "loose"
+		}
+		fmt.Fprintf(out, " (%s, %s)", kind, spacing)
+	}
+	out.WriteString("\n")
+
+	for _, child := range block.Children {
+		dumpBlocks(child, depth+1, out)
+	}
+}
+
+// A subset of the block examples of the CommonMark spec (version 0.31.2),
+// numbered like in the spec, with the block tree expected for each. Unlike
+// TestCommonMarkSpecBlocks, which runs all of them, these also check the text
+// of paragraphs and headings. The expected trees follow the spec's HTML
+// output.
+var commonMarkSampleExamples = []struct {
+	example  int
+	markdown string
+	expected string
+}{
+	// Tabs
+	{1, "\tfoo\tbaz\t\tbim", `code_block "foo\tbaz\t\tbim"`},
+	{2, "  \tfoo\tbaz\t\tbim", `code_block "foo\tbaz\t\tbim"`},
+	{4, "  - foo\n\n\tbar", `
+list (bullet, loose)
+  item
+    paragraph "foo"
+    paragraph "bar"`},
+	{5, "- foo\n\n\t\tbar", `
+list (bullet, loose)
+  item
+    paragraph "foo"
+    code_block "  bar"`},
+	{6, ">\t\tfoo", `
+block_quote
+  code_block "  foo"`},
+	{7, "-\t\tfoo", `
+list (bullet, tight)
+  item
+    code_block "  foo"`},
+	{8, "    foo\n\tbar", `code_block "foo\nbar"`},
+	{9, " - foo\n   - bar\n\t - baz", `
+list (bullet, tight)
+  item
+    paragraph "foo"
+    list (bullet, tight)
+      item
+        paragraph "bar"
+        list (bullet, tight)
+          item
+            paragraph "baz"`},
+	{10, "#\tFoo", `heading 1 "Foo"`},
+	{11, "*\t*\t*\t", `thematic_break`},
+
+	// Precedence
+	{42, "- `one\n- two`", `
+list (bullet, tight)
+  item
+    paragraph "` + "`one" + `"
+  item
+    paragraph "two` + "`" + `"`},
+
+	// Thematic breaks
+	{43, "***\n---\n___", "thematic_break\nthematic_break\nthematic_break"},
+	{44, "+++", `paragraph "+++"`},
+	{45, "===", `paragraph "==="`},
+	{46, "--\n**\n__", `paragraph "--\n**\n__"`},
+	{47, " ***\n  ***\n   ***", "thematic_break\nthematic_break\nthematic_break"},
+	{48, "    ***", `code_block "***"`},
+	{49, "Foo\n    ***", `paragraph "Foo\n***"`},
+	{51, " - - -", `thematic_break`},
+	{53, "-     -      -      -", `thematic_break`},
+	{55, "_ _ _ _ a\n\na------\n\n---a---", `
+paragraph "_ _ _ _ a"
+paragraph "a------"
+paragraph "---a---"`},
+	{57, "- foo\n***\n- bar", `
+list (bullet, tight)
+  item
+    paragraph "foo"
+thematic_break
+list (bullet, tight)
+  item
+    paragraph "bar"`},
+	{58, "Foo\n***\nbar", `
+paragraph "Foo"
+thematic_break
+paragraph "bar"`},
+	{59, "Foo\n---\nbar", `
+heading 2 "Foo"
+paragraph "bar"`},
+	{60, "* Foo\n* * *\n* Bar", `
+list (bullet, tight)
+  item
+    paragraph "Foo"
+thematic_break
+list (bullet, tight)
+  item
+    paragraph "Bar"`},
+	{61, "- Foo\n- * * *", `
+list (bullet, tight)
+  item
+    paragraph "Foo"
+  item
+    thematic_break`},
+
+	// ATX headings
+	{62, "# foo\n## foo\n### foo\n#### foo\n##### foo\n###### foo", `
+heading 1 "foo"
+heading 2 "foo"
+heading 3 "foo"
+heading 4 "foo"
+heading 5 "foo"
+heading 6 "foo"`},
+	{63, "####### foo", `paragraph "####### foo"`},
+	{64, "#5 bolt\n\n#hashtag", `
+paragraph "#5 bolt"
+paragraph "#hashtag"`},
+	{68, " ### foo\n  ## foo\n   # foo", `
+heading 3 "foo"
+heading 2 "foo"
+heading 1 "foo"`},
+	{69, "    # foo", `code_block "# foo"`},
+	{70, "foo\n    # bar", `paragraph "foo\n# bar"`},
+	{71, "## foo ##\n  ###   bar    ###", `
+heading 2 "foo"
+heading 3 "bar"`},
+	{73, "### foo ###     ", `heading 3 "foo"`},
+	{74, "### foo ### b", `heading 3 "foo ### b"`},
+	{75, "# foo#", `heading 1 "foo#"`},
+	{77, "****\n## foo\n****", `
+thematic_break
+heading 2 "foo"
+thematic_break`},
+	{78, "Foo bar\n# baz\nBar foo", `
+paragraph "Foo bar"
+heading 1 "baz"
+paragraph "Bar foo"`},
+	{79, "## \n#\n### ###", `
+heading 2 ""
+heading 1 ""
+heading 3 ""`},
+
+	// Setext headings
+	{80, "Foo *bar*\n=========\n\nFoo *bar*\n---------", `
+heading 1 "Foo *bar*"
+heading 2 "Foo *bar*"`},
+	{81, "Foo *bar\nbaz*\n====", `heading 1 "Foo *bar baz*"`},
+	{85, "    Foo\n    ---\n\n    Foo\n---", `
+code_block "Foo\n---\n\nFoo"
+thematic_break`},
+	{88, "Foo\n= =\n\nFoo\n--- -", `
+paragraph "Foo\n= ="
+paragraph "Foo"
+thematic_break`},
+	{92, "> Foo\n---", `
+block_quote
+  paragraph "Foo"
+thematic_break`},
+	{93, "> foo\nbar\n===", `
+block_quote
+  paragraph "foo\nbar\n==="`},
+	{94, "- Foo\n---", `
+list (bullet, tight)
+  item
+    paragraph "Foo"
+thematic_break`},
+	{96, "---\nFoo\n---\nBar\n---\nBaz", `
+thematic_break
+heading 2 "Foo"
+heading 2 "Bar"
+paragraph "Baz"`},
+	{97, "\n====", `paragraph "===="`},
+	{98, "---\n---", "thematic_break\nthematic_break"},
+	{99, "- foo\n-----", `
+list (bullet, tight)
+  item
+    paragraph "foo"
+thematic_break`},
+	{101, "> foo\n-----", `
+block_quote
+  paragraph "foo"
+thematic_break`},
+
+	// Indented code blocks
+	{107, "    a simple\n      indented code block", `code_block "a simple\n  indented code block"`},
+	{108, "  - foo\n\n    bar", `
+list (bullet, loose)
+  item
+    paragraph "foo"
+    paragraph "bar"`},
+	{109, "1.  foo\n\n    - bar", `
+list (ordered 1, loose)
+  item
+    paragraph "foo"
+    list (bullet, tight)
+      item
+        paragraph "bar"`},
+	{111, "    chunk1\n\n    chunk2\n  \n \n \n    chunk3", `code_block "chunk1\n\nchunk2\n\n\n\nchunk3"`},
+	{113, "Foo\n    bar", `paragraph "Foo\nbar"`},
+	{114, "    foo\nbar", `
+code_block "foo"
+paragraph "bar"`},
+	{115, "# Heading\n    foo\nHeading\n------\n    foo\n----", `
+heading 1 "Heading"
+code_block "foo"
+heading 2 "Heading"
+code_block "foo"
+thematic_break`},
+	{117, "\n    \n    foo\n    ", `code_block "foo"`},
+
+	// Fenced code blocks
+	{119, "```\n<\n >\n```", `code_block info="" "<\n >"`},
+	{120, "~~~\n<\n >\n~~~", `code_block info="" "<\n >"`},
+	{121, "``\nfoo\n``", `paragraph "` + "``\\nfoo\\n``" + `"`},
+	{122, "```\naaa\n~~~\n```", `code_block info="" "aaa\n~~~"`},
+	{124, "````\naaa\n```\n``````", `code_block info="" "aaa\n` + "```" + `"`},
+	{126, "```", `code_block info="" ""`},
+	{127, "`````\n\n```\naaa", `code_block info="" "\n` + "```" + `\naaa"`},
+	{128, "> ```\n> aaa\n\nbbb", `
+block_quote
+  code_block info="" "aaa"
+paragraph "bbb"`},
+	{131, " ```\n aaa\naaa\n```", `code_block info="" "aaa\naaa"`},
+	{133, "   ```\n   aaa\n    aaa\n  aaa\n   ```", `code_block info="" "aaa\n aaa\naaa"`},
+	{134, "    ```\n    aaa\n    ```", "code_block \"```\\naaa\\n```\""},
+	{137, "```\naaa\n    ```", `code_block info="" "aaa\n    ` + "```" + `"`},
+	{140, "foo\n```\nbar\n```\nbaz", `
+paragraph "foo"
+code_block info="" "bar"
+paragraph "baz"`},
+	{142, "```ruby\ndef foo(x)\n  return 3\nend\n```", `code_block info="ruby" "def foo(x)\n  return 3\nend"`},
+	{143, "~~~~    ruby startline=3 $%@#$\ndef foo(x)\n  return 3\nend\n~~~~~~~", `code_block info="ruby startline=3 $%@#$" "def foo(x)\n  return 3\nend"`},
+	{145, "``` aa ```\nfoo", `paragraph "` + "``` aa ```\\nfoo" + `"`},
+	{146, "~~~ aa ``` ~~~\nfoo\n~~~", `code_block info="aa ` + "```" + ` ~~~" "foo"`},
+
+	// HTML blocks
+	{148, "<table><tr><td>\n<pre>\n**Hello**,\n\n_world_.\n</pre>\n</td></tr></table>", `
+html_block "<table><tr><td>\n<pre>\n**Hello**,"
+paragraph "_world_.\n</pre>"
+html_block "</td></tr></table>"`},
+	{150, " <div>\n  *hello*\n         <foo><a>", `html_block " <div>\n  *hello*\n         <foo><a>"`},
+	{151, "</div>\n*foo*", `html_block "</div>\n*foo*"`},
+	{152, "<DIV CLASS=\"foo\">\n\n*Markdown*\n\n</DIV>", `
+html_block "<DIV CLASS=\"foo\">"
+paragraph "*Markdown*"
+html_block "</DIV>"`},
+	{161, "<div></div>\n``` c\nint x = 33;\n```", "html_block \"<div></div>\\n``` c\\nint x = 33;\\n```\""},
+	{164, "<del>*foo*</del>", `paragraph "<del>*foo*</del>"`},
+	{170, "<style\n  type=\"text/css\">\nh1 {color:red;}\n\np {color:blue;}\n</style>\nokay", `
+html_block "<style\n  type=\"text/css\">\nh1 {color:red;}\n\np {color:blue;}\n</style>"
+paragraph "okay"`},
+	{172, "> <div>\n> foo\n\nbar", `
+block_quote
+  html_block "<div>\nfoo"
+paragraph "bar"`},
+	{173, "- <div>\n- foo", `
+list (bullet, tight)
+  item
+    html_block "<div>"
+  item
+    paragraph "foo"`},
+	{178, "<!-- Foo\n\nbar\n   baz -->\nokay", `
+html_block "<!-- Foo\n\nbar\n   baz -->"
+paragraph "okay"`},
+	{181, "  <!-- foo -->\n\n    <!-- foo -->", `
+html_block "  <!-- foo -->"
+code_block "<!-- foo -->"`},
+	{183, "Foo\n<div>\nbar\n</div>", `
+paragraph "Foo"
+html_block "<div>\nbar\n</div>"`},
+	{185, "Foo\n<a href=\"bar\">\nbaz", `paragraph "Foo\n<a href=\"bar\">\nbaz"`},
+
+	// Link reference definitions
+	{192, "[foo]: /url \"title\"\n\n[foo]", `paragraph "[foo]"`},
+	{193, "   [foo]: \n      /url  \n           'the title'  \n\n[foo]", `paragraph "[foo]"`},
+	{197, "[foo]: /url 'title\n\nwith blank line'\n\n[foo]", `
+paragraph "[foo]: /url 'title"
+paragraph "with blank line'"
+paragraph "[foo]"`},
+	{199, "[foo]:\n\n[foo]", `
+paragraph "[foo]:"
+paragraph "[foo]"`},
+	{207, "[foo]: /url \"title\" ok", `paragraph "[foo]: /url \"title\" ok"`},
+	{209, "    [foo]: /url \"title\"\n\n[foo]", `
+code_block "[foo]: /url \"title\""
+paragraph "[foo]"`},
+	{211, "Foo\n[bar]: /baz\n\n[bar]", `
+paragraph "Foo\n[bar]: /baz"
+paragraph "[bar]"`},
+	{212, "# [Foo]\n[foo]: /url\n> bar", `
+heading 1 "[Foo]"
+block_quote
+  paragraph "bar"`},
+	{215, "[foo]: /foo-url \"foo\"\n[bar]: /bar-url\n  \"bar\"\n[baz]: /baz-url\n\n[foo],\n[bar],\n[baz]", `paragraph "[foo],\n[bar],\n[baz]"`},
+
+	// Paragraphs
+	{219, "aaa\n\nbbb", "paragraph \"aaa\"\nparagraph \"bbb\""},
+	{220, "aaa\nbbb\n\nccc\nddd", "paragraph \"aaa\\nbbb\"\nparagraph \"ccc\\nddd\""},
+	{222, "  aaa\n bbb", `paragraph "aaa\nbbb"`},
+	{223, "aaa\n             bbb\n                                       ccc", `paragraph "aaa\nbbb\nccc"`},
+	{225, "    aaa\nbbb", "code_block \"aaa\"\nparagraph \"bbb\""},
+
+	// Blank lines
+	{227, "  \n\naaa\n  \n\n# aaa\n\n  ", "paragraph \"aaa\"\nheading 1 \"aaa\""},
+
+	// Block quotes
+	{228, "> # Foo\n> bar\n> baz", `
+block_quote
+  heading 1 "Foo"
+  paragraph "bar\nbaz"`},
+	{229, "># Foo\n>bar\n> baz", `
+block_quote
+  heading 1 "Foo"
+  paragraph "bar\nbaz"`},
+	{231, "    > # Foo\n    > bar\n    > baz", `code_block "> # Foo\n> bar\n> baz"`},
+	{232, "> # Foo\n> bar\nbaz", `
+block_quote
+  heading 1 "Foo"
+  paragraph "bar\nbaz"`},
+	{234, "> foo\n---", `
+block_quote
+  paragraph "foo"
+thematic_break`},
+	{235, "> - foo\n- bar", `
+block_quote
+  list (bullet, tight)
+    item
+      paragraph "foo"
+list (bullet, tight)
+  item
+    paragraph "bar"`},
+	{236, ">     foo\n    bar", `
+block_quote
+  code_block "foo"
+code_block "bar"`},
+	{237, "> ```\nfoo\n```", `
+block_quote
+  code_block info="" ""
+paragraph "foo"
+code_block info="" ""`},
+	{238, "> foo\n    - bar", `
+block_quote
+  paragraph "foo\n- bar"`},
+	{239, ">", `block_quote`},
+	{242, "> foo\n\n> bar", `
+block_quote
+  paragraph "foo"
+block_quote
+  paragraph "bar"`},
+	{244, "> foo\n>\n> bar", `
+block_quote
+  paragraph "foo"
+  paragraph "bar"`},
+	{247, "> bar\nbaz\n> foo", `
+block_quote
+  paragraph "bar\nbaz\nfoo"`},
+	{249, "> bar\n>\nbaz", `
+block_quote
+  paragraph "bar"
+paragraph "baz"`},
+	{250, "> > > foo\nbar", `
+block_quote
+  block_quote
+    block_quote
+      paragraph "foo\nbar"`},
+	{252, ">     code\n\n>    not code", `
+block_quote
+  code_block "code"
+block_quote
+  paragraph "not code"`},
+
+	// List items
+	{253, "A paragraph\nwith two lines.\n\n    indented code\n\n> A block quote.", `
+paragraph "A paragraph\nwith two lines."
+code_block "indented code"
+block_quote
+  paragraph "A block quote."`},
+	{254, "1.  A paragraph\n    with two lines.\n\n        indented code\n\n    > A block quote.", `
+list (ordered 1, loose)
+  item
+    paragraph "A paragraph\nwith two lines."
+    code_block "indented code"
+    block_quote
+      paragraph "A block quote."`},
+	{255, "- one\n\n two", `
+list (bullet, tight)
+  item
+    paragraph "one"
+paragraph "two"`},
+	{256, "- one\n\n  two", `
+list (bullet, loose)
+  item
+    paragraph "one"
+    paragraph "two"`},
+	{257, " -    one\n\n     two", `
+list (bullet, tight)
+  item
+    paragraph "one"
+code_block " two"`},
+	{259, "   > > 1.  one\n>>\n>>     two", `
+block_quote
+  block_quote
+    list (ordered 1, loose)
+      item
+        paragraph "one"
+        paragraph "two"`},
+	{261, "-one\n\n2.two", "paragraph \"-one\"\nparagraph \"2.two\""},
+	{262, "- foo\n\n\n  bar", `
+list (bullet, loose)
+  item
+    paragraph "foo"
+    paragraph "bar"`},
+	{264, "- Foo\n\n      bar\n\n\n      baz", `
+list (bullet, loose)
+  item
+    paragraph "Foo"
+    code_block "bar\n\n\nbaz"`},
+	{265, "123456789. ok", `
+list (ordered 123456789, tight)
+  item
+    paragraph "ok"`},
+	{266, "1234567890. not ok", `paragraph "1234567890. not ok"`},
+	{267, "0. ok", `
+list (ordered 0, tight)
+  item
+    paragraph "ok"`},
+	{269, "-1. not ok", `paragraph "-1. not ok"`},
+	{270, "- foo\n\n      bar", `
+list (bullet, loose)
+  item
+    paragraph "foo"
+    code_block "bar"`},
+	{273, "   indented code\n\nparagraph\n\n    more code", `
+paragraph "indented code"
+paragraph "paragraph"
+code_block "more code"`},
+	{274, "1.     indented code\n\n   paragraph\n\n       more code", `
+list (ordered 1, loose)
+  item
+    code_block "indented code"
+    paragraph "paragraph"
+    code_block "more code"`},
+	{278, "-\n  foo\n-\n  ```\n  bar\n  ```\n-\n      baz", `
+list (bullet, tight)
+  item
+    paragraph "foo"
+  item
+    code_block info="" "bar"
+  item
+    code_block "baz"`},
+	{280, "-\n\n  foo", `
+list (bullet, tight)
+  item
+paragraph "foo"`},
+	{281, "- foo\n-\n- bar", `
+list (bullet, tight)
+  item
+    paragraph "foo"
+  item
+  item
+    paragraph "bar"`},
+	{283, "1. foo\n2.\n3. bar", `
+list (ordered 1, tight)
+  item
+    paragraph "foo"
+  item
+  item
+    paragraph "bar"`},
+	{285, "foo\n*\n\nfoo\n1.", "paragraph \"foo\\n*\"\nparagraph \"foo\\n1.\""},
+	{289, "    1.  A paragraph\n        with two lines.", `code_block "1.  A paragraph\n    with two lines."`},
+	{290, "  1.  A paragraph\nwith two lines.\n\n          indented code\n\n      > A block quote.", `
+list (ordered 1, loose)
+  item
+    paragraph "A paragraph\nwith two lines."
+    code_block "indented code"
+    block_quote
+      paragraph "A block quote."`},
+	{292, "> 1. > Blockquote\ncontinued here.", `
+block_quote
+  list (ordered 1, tight)
+    item
+      block_quote
+        paragraph "Blockquote\ncontinued here."`},
+	{294, "- foo\n  - bar\n    - baz\n      - boo", `
+list (bullet, tight)
+  item
+    paragraph "foo"
+    list (bullet, tight)
+      item
+        paragraph "bar"
+        list (bullet, tight)
+          item
+            paragraph "baz"
+            list (bullet, tight)
+              item
+                paragraph "boo"`},
+	{295, "- foo\n - bar\n  - baz\n   - boo", `
+list (bullet, tight)
+  item
+    paragraph "foo"
+  item
+    paragraph "bar"
+  item
+    paragraph "baz"
+  item
+    paragraph "boo"`},
+	{296, "10) foo\n    - bar", `
+list (ordered 10, tight)
+  item
+    paragraph "foo"
+    list (bullet, tight)
+      item
+        paragraph "bar"`},
+	{297, "10) foo\n   - bar", `
+list (ordered 10, tight)
+  item
+    paragraph "foo"
+list (bullet, tight)
+  item
+    paragraph "bar"`},
+	{298, "- - foo", `
+list (bullet, tight)
+  item
+    list (bullet, tight)
+      item
+        paragraph "foo"`},
+	{300, "- # Foo\n- Bar\n  ---\n  baz", `
+list (bullet, tight)
+  item
+    heading 1 "Foo"
+  item
+    heading 2 "Bar"
+    paragraph "baz"`},
+
+	// Lists
+	{301, "- foo\n- bar\n+ baz", `
+list (bullet, tight)
+  item
+    paragraph "foo"
+  item
+    paragraph "bar"
+list (bullet, tight)
+  item
+    paragraph "baz"`},
+	{302, "1. foo\n2. bar\n3) baz", `
+list (ordered 1, tight)
+  item
+    paragraph "foo"
+  item
+    paragraph "bar"
+list (ordered 3, tight)
+  item
+    paragraph "baz"`},
+	{303, "Foo\n- bar\n- baz", `
+paragraph "Foo"
+list (bullet, tight)
+  item
+    paragraph "bar"
+  item
+    paragraph "baz"`},
+	{304, "The number of windows in my house is\n14.  The number of doors is 6.", `paragraph "The number of windows in my house is\n14.  The number of doors is 6."`},
+	{305, "The number of windows in my house is\n1.  The number of doors is 6.", `
+paragraph "The number of windows in my house is"
+list (ordered 1, tight)
+  item
+    paragraph "The number of doors is 6."`},
+	{306, "- foo\n\n- bar\n\n\n- baz", `
+list (bullet, loose)
+  item
+    paragraph "foo"
+  item
+    paragraph "bar"
+  item
+    paragraph "baz"`},
+	{308, "- foo\n- bar\n\n<!-- -->\n\n- baz\n- bim", `
+list (bullet, tight)
+  item
+    paragraph "foo"
+  item
+    paragraph "bar"
+html_block "<!-- -->"
+list (bullet, tight)
+  item
+    paragraph "baz"
+  item
+    paragraph "bim"`},
+	{310, "- a\n - b\n  - c\n   - d\n  - e\n - f\n- g", `
+list (bullet, tight)
+  item
+    paragraph "a"
+  item
+    paragraph "b"
+  item
+    paragraph "c"
+  item
+    paragraph "d"
+  item
+    paragraph "e"
+  item
+    paragraph "f"
+  item
+    paragraph "g"`},
+	{312, "- a\n - b\n  - c\n   - d\n    - e", `
+list (bullet, tight)
+  item
+    paragraph "a"
+  item
+    paragraph "b"
+  item
+    paragraph "c"
+  item
+    paragraph "d\n- e"`},
+	{313, "1. a\n\n  2. b\n\n    3. c", `
+list (ordered 1, loose)
+  item
+    paragraph "a"
+  item
+    paragraph "b"
+code_block "3. c"`},
+	{314, "- a\n- b\n\n- c", `
+list (bullet, loose)
+  item
+    paragraph "a"
+  item
+    paragraph "b"
+  item
+    paragraph "c"`},
+	{315, "* a\n*\n\n* c", `
+list (bullet, loose)
+  item
+    paragraph "a"
+  item
+  item
+    paragraph "c"`},
+	{316, "- a\n- b\n\n  c\n- d", `
+list (bullet, loose)
+  item
+    paragraph "a"
+  item
+    paragraph "b"
+    paragraph "c"
+  item
+    paragraph "d"`},
+	{318, "- a\n- ```\n  b\n\n\n  ```\n- c", `
+list (bullet, tight)
+  item
+    paragraph "a"
+  item
+    code_block info="" "b\n\n"
+  item
+    paragraph "c"`},
+	{319, "- a\n  - b\n\n    c\n- d", `
+list (bullet, tight)
+  item
+    paragraph "a"
+    list (bullet, loose)
+      item
+        paragraph "b"
+        paragraph "c"
+  item
+    paragraph "d"`},
+	{320, "* a\n  > b\n  >\n* c", `
+list (bullet, tight)
+  item
+    paragraph "a"
+    block_quote
+      paragraph "b"
+  item
+    paragraph "c"`},
+	{321, "- a\n  > b\n  ```\n  c\n  ```\n- d", `
+list (bullet, tight)
+  item
+    paragraph "a"
+    block_quote
+      paragraph "b"
+    code_block info="" "c"
+  item
+    paragraph "d"`},
+	{324, "1. ```\n   foo\n   ```\n\n   bar", `
+list (ordered 1, loose)
+  item
+    code_block info="" "foo"
+    paragraph "bar"`},
+	{325, "* foo\n  * bar\n\n  baz", `
+list (bullet, loose)
+  item
+    paragraph "foo"
+    list (bullet, tight)
+      item
+        paragraph "bar"
+    paragraph "baz"`},
+	{326, "- a\n  - b\n  - c\n\n- d\n  - e\n  - f", `
+list (bullet, loose)
+  item
+    paragraph "a"
+    list (bullet, tight)
+      item
+        paragraph "b"
+      item
+        paragraph "c"
+  item
+    paragraph "d"
+    list (bullet, tight)
+      item
+        paragraph "e"
+      item
+        paragraph "f"`},
+}
+
+func TestCommonMarkBlockSamples(t *testing.T) {
+	for _, test := range commonMarkSampleExamples {
+		doc := parseDocument(strings.Split(test.markdown, "\n"))
+
+		var got strings.Builder
+		for _, block := range doc.Root.Children {
+			dumpBlocks(block, 0, &got)
+		}
+
+		expected := strings.TrimPrefix(test.expected, "\n")
+		if strings.TrimSuffix(got.String(), "\n") != expected {
+			t.Errorf("\nExample %d:\n%s\nExpected:\n%s\nGot:\n%s", test.example, test.markdown, expected, got.String())
+		}
+	}
+}
+
+func TestGFMTables(t *testing.T) {
+	doc := parseDocument(strings.Split("Intro text\n| a | b |\n| --- | :-: |\n| 1 | 2 |\nno pipe row\n\n| not | a table |", "\n"))
+
+	var got strings.Builder
+	for _, block := range doc.Root.Children {
+		dumpBlocks(block, 0, &got)
+	}
+
+	expected := `paragraph "Intro text"
+table "| a | b |\n| --- | :-: |\n| 1 | 2 |\nno pipe row"
+paragraph "| not | a table |"
+`
+	if got.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got.String())
+	}
+}
+
+// renderBlocksHTML renders the block tree like the CommonMark spec does,
+// leaving the inline content of paragraphs and headings as text
+func renderBlocksHTML(block *Block, tight bool, out *strings.Builder) {
+	switch block.Type {
+	case BLOCK_PARAGRAPH, BLOCK_TABLE:
+		var lines []string
+		for _, line := range block.Content {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+		text := strings.Join(lines, "\n")
+		if tight {
+			out.WriteString(text + "\n")
+		} else {
+			out.WriteString("<p>" + text + "</p>\n")
+		}
+	case BLOCK_HEADING:
+		fmt.Fprintf(out, "<h%d>%s</h%d>\n", block.Level, block.Text, block.Level)
+	case BLOCK_THEMATIC_BREAK:
+		out.WriteString("<hr />\n")
+	case BLOCK_CODE:
+		out.WriteString("<pre><code")
+		if block.Fence != nil && block.Fence.Info != "" {
+			fmt.Fprintf(out, ` class="language-%s"`, html.EscapeString(strings.Fields(block.Fence.Info)[0]))
+		}
+		out.WriteString(">")
+		for _, line := range block.Content {
+			out.WriteString(html.EscapeString(line) + "\n")
+		}
+		out.WriteString("</code></pre>\n")
+	case BLOCK_HTML:
+		out.WriteString(strings.Join(block.Content, "\n") + "\n")
+	case BLOCK_QUOTE:
+		out.WriteString("<blockquote>\n")
+		for _, child := range block.Children {
+			renderBlocksHTML(child, false, out)
+		}
+		out.WriteString("</blockquote>\n")
+	case BLOCK_LIST:
+		tag := "ul"
+		if block.List.Ordered {
+			tag = "ol"
+		}
+		if block.List.Ordered && block.List.Start != 1 {
+			fmt.Fprintf(out, "<ol start=\"%d\">\n", block.List.Start)
+		} else {
+			out.WriteString("<" + tag + ">\n")
+		}
+		for _, item := range block.Children {
+			out.WriteString("<li>")
+			for _, child := range item.Children {
+				renderBlocksHTML(child, block.List.Tight, out)
+			}
+			out.WriteString("</li>\n")
+		}
+		out.WriteString("</" + tag + ">\n")
+	default:
+		for _, child := range block.Children {
+			renderBlocksHTML(child, false, out)
+		}
+	}
+}
+
+var (
+	reHTMLTag = regexp.MustCompile(`<(/?)([a-zA-Z][a-zA-Z0-9]*)((?:\s+[^<>]*?)?)\s*/?>`)
+	// Tags of the blocks the parser builds, the rest of the HTML is text
+	specBlockTags = map[string]bool{
+		"p": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+		"hr": true, "pre": true, "blockquote": true, "ul": true, "ol": true, "li": true,
+	}
+)
+
+// normalizeBlocksHTML reduces HTML to the tags of its blocks, so the block
+// tree can be compared with the spec's output without the inline content.
+// The text between block tags is replaced by "…", except for code blocks
+// whose content is kept as it is.
+func normalizeBlocksHTML(source string) string {
+	var out []string
+	inPre := false
+	text := func(text string) {
+		text = html.UnescapeString(text)
+		if inPre {
+			if text != "" {
+				out = append(out, fmt.Sprintf("%q", text))
+			}
+		} else if strings.TrimSpace(text) != "" && (len(out) == 0 || out[len(out)-1] != "…") {
+			out = append(out, "…")
+		}
+	}
+
+	last := 0
+	for _, match := range reHTMLTag.FindAllStringSubmatchIndex(source, -1) {
+		closing := source[match[2]:match[3]] == "/"
+		name := strings.ToLower(source[match[4]:match[5]])
+		attributes := strings.TrimSpace(source[match[6]:match[7]])
+
+		if inPre && name == "code" {
+			// The code element of a code block, only its class is kept
+			text(source[last:match[0]])
+			if !closing && attributes != "" {
+				out = append(out, "<code "+attributes+">")
+			}
+			last = match[1]
+			continue
+		}
+		if !specBlockTags[name] {
+			continue
+		}
+
+		text(source[last:match[0]])
+		last = match[1]
+		inPre = name == "pre" && !closing
+		if closing {
+			out = append(out, "</"+name+">")
+		} else if attributes != "" {
+			out = append(out, "<"+name+" "+attributes+">")
+		} else {
+			out = append(out, "<"+name+">")
+		}
+	}
+	text(source[last:])
+
+	return strings.Join(out, "\n")
+}
+
+// Block examples of the CommonMark spec (version 0.31.2) that the parser
+// doesn't handle like the spec yet, with the reason. None at the moment, new
+// gaps are listed here instead of being left out of the test data.
+var commonMarkSpecSkips = map[int]string{}
+
+// TestCommonMarkSpecBlocks runs every example of the block sections of the
+// CommonMark spec (https://spec.commonmark.org/0.31.2/, CC-BY-SA 4.0), taken
+// from its spec.json. Only the block structure and the content of code
+// blocks are compared, inline content is left to the queries.
+func TestCommonMarkSpecBlocks(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "commonmark-spec-blocks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var examples []struct {
+		Example  int    `json:"example"`
+		Section  string `json:"section"`
+		Markdown string `json:"markdown"`
+		HTML     string `json:"html"`
+	}
+	if err := json.Unmarshal(data, &examples); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, example := range examples {
+		doc := parseDocument(strings.Split(strings.TrimSuffix(example.Markdown, "\n"), "\n"))
+		var got strings.Builder
+		renderBlocksHTML(doc.Root, false, &got)
+
+		expected := normalizeBlocksHTML(example.HTML)
+		actual := normalizeBlocksHTML(got.String())
+		reason, skipped := commonMarkSpecSkips[example.Example]
+		switch {
+		case skipped && expected == actual:
+			t.Errorf("Example %d (%s) passes now, remove it from the skipped examples (%s)", example.Example, example.Section, reason)
+		case !skipped && expected != actual:
+			t.Errorf("\nExample %d (%s):\n%s\nExpected:\n%s\nGot:\n%s", example.Example, example.Section, example.Markdown, expected, actual)
+		}
+	}
+}
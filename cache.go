@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"time"
+)
+
+// cachedFile is a markdown file kept in memory together with its parsed
+// document
+type cachedFile struct {
+	modTime time.Time
+	size    int64
+	lines   []string
+	doc     *Document
+}
+
+// The files read so far, keyed by path. The cache is only enabled (non-nil)
+// in long running sessions like the REPL, a single query reads every file
+// once anyway.
+var fileCache map[string]*cachedFile
+
+// readMarkdownFile returns the lines of a file, from the cache if the file
+// hasn't been modified since it was read
+func readMarkdownFile(path string) ([]string, error) {
+	var fileInfo os.FileInfo
+	if fileCache != nil {
+		var err error
+		fileInfo, err = os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if cached, ok := fileCache[path]; ok && cached.modTime.Equal(fileInfo.ModTime()) && cached.size == fileInfo.Size() {
+			return cached.lines, nil
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if fileCache != nil {
+		fileCache[path] = &cachedFile{modTime: fileInfo.ModTime(), size: fileInfo.Size(), lines: lines}
+	}
+	return lines, nil
+}
+
+// cachedDocument parses the body of a file into a block tree, or returns the
+// tree parsed when the file was last read
+func cachedDocument(path string, body []string) *Document {
+	cached, ok := fileCache[path]
+	if !ok {
+		return parseDocument(body)
+	}
+	if cached.doc == nil {
+		cached.doc = parseDocument(body)
+	}
+	return cached.doc
+}
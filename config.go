@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Name of the configuration file in the vault root. The user configuration is
+// read from <user config dir>/dynomark/config.toml.
+const configFileName = ".dynomark.toml"
+
+// Config holds the settings of a .dynomark.toml file:
+//
+//	from = ["notes/", "projects/"]   # sources of queries without FROM
+//	ignore = ["templates/", "*.draft.md"]
+//	date_format = "YYYY-MM-DD"       # format of file.cday and file.mday
+//	extensions = [".md", ".txt"]     # files picked up in directories, unless -ext is given
+//
+//	[tasks]
+//	states = [" ", "x", "X", "/", "-", ">"]
+//	done = ["x", "X", "-"]           # states matched by CHECKED
+//
+//	[queries]
+//	weekly-review = 'TASK FROM "projects/" WHERE NOT CHECKED'
+type Config struct {
+	From       []string
+	Ignore     []string
+	DateFormat string
+	Extensions []string
+	TaskStates []string
+	DoneStates []string
+	Queries    map[string]string
+}
+
+// The configuration used by queries, the defaults unless loadConfig found a
+// configuration file
+var config = defaultConfig()
+
+func defaultConfig() Config {
+	return Config{
+		DateFormat: "2006-01-02",
+		TaskStates: []string{" ", "x", "X", ".", "o", "O", "0"},
+		DoneStates: []string{"x", "X"},
+		Queries:    make(map[string]string),
+	}
+}
+
+// loadConfig reads the user configuration and then the one in the vault
+// root. Settings of the vault override the user's, and named queries of both
+// are available.
+func loadConfig() error {
+	config = defaultConfig()
+
+	var paths []string
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, "dynomark", "config.toml"))
+	}
+	if vaultRoot != "" {
+		paths = append(paths, filepath.Join(vaultRoot, configFileName))
+	}
+
+	for _, path := range paths {
+		if err := config.load(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// load reads a configuration file over the current settings
+func (c *Config) load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	table := ""
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			if table != "tasks" && table != "queries" {
+				return fmt.Errorf("%s:%d: unknown table [%s]", path, lineNumber, table)
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected key = value", path, lineNumber)
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"'`)
+		value = stripTOMLComment(value)
+
+		// Arrays can span multiple lines
+		for strings.HasPrefix(value, "[") && !strings.HasSuffix(value, "]") && scanner.Scan() {
+			lineNumber++
+			value += " " + stripTOMLComment(scanner.Text())
+		}
+
+		if err := c.set(table, key, value); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// set applies a single key of a configuration file
+func (c *Config) set(table string, key string, value string) error {
+	switch table + "." + key {
+	case ".from":
+		values, err := parseTOMLStrings(value)
+		c.From = values
+		return err
+	case ".ignore":
+		values, err := parseTOMLStrings(value)
+		c.Ignore = values
+		return err
+	case ".date_format":
+		format, err := parseTOMLString(value)
+		c.DateFormat = convertDateFormat(format)
+		return err
+	case ".extensions":
+		values, err := parseTOMLStrings(value)
+		c.Extensions = values
+		return err
+	case "tasks.states":
+		values, err := parseTOMLStrings(value)
+		c.TaskStates = values
+		return err
+	case "tasks.done":
+		values, err := parseTOMLStrings(value)
+		c.DoneStates = values
+		return err
+	}
+
+	if table == "queries" {
+		query, err := parseTOMLString(value)
+		c.Queries[key] = query
+		return err
+	}
+
+	if table != "" {
+		key = table + "." + key
+	}
+	return fmt.Errorf("unknown setting %s", key)
+}
+
+// runSavedQuery runs the "run" subcommand, which executes a named query of
+// the configuration or lists them if no name is given
+func runSavedQuery(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	showAST := flags.Bool("ast", false, "print the parsed query as JSON before showing the results")
+	addQueryFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: dynomark run [flags] [name]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	// Flags can also come after the name of the query
+	name := flags.Arg(0)
+	if flags.NArg() > 1 {
+		flags.Parse(flags.Args()[1:])
+		if flags.NArg() > 0 {
+			flags.Usage()
+			return fmt.Errorf("unexpected argument %s", flags.Arg(0))
+		}
+	}
+
+	if err := resolveVaultRoot(rootFlag); err != nil {
+		return err
+	}
+	if err := loadConfig(); err != nil {
+		return err
+	}
+
+	if name == "" {
+		if len(config.Queries) == 0 {
+			return fmt.Errorf("no saved queries in the configuration")
+		}
+		for _, name := range config.queryNames() {
+			fmt.Printf("%s: %s\n", name, config.Queries[name])
+		}
+		return nil
+	}
+
+	query, ok := config.Queries[name]
+	if !ok {
+		return fmt.Errorf("no saved query named %s", name)
+	}
+
+	result, err := executeQuery(query, *showAST)
+	if err != nil {
+		return fmt.Errorf("query %s: %w", name, err)
+	}
+	fmt.Println(result)
+	return nil
+}
+
+// queryNames returns the names of the saved queries in alphabetical order
+func (c *Config) queryNames() []string {
+	names := make([]string, 0, len(c.Queries))
+	for name := range c.Queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseTOMLString parses a basic ("...") or literal ('...') TOML string
+func parseTOMLString(value string) (string, error) {
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return value[1 : len(value)-1], nil
+	}
+	if strings.HasPrefix(value, `"`) {
+		s, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", value)
+		}
+		return s, nil
+	}
+	return "", fmt.Errorf("expected a string, got %s", value)
+}
+
+// parseTOMLStrings parses a TOML array of strings. A single string is read as
+// an array with one element.
+func parseTOMLStrings(value string) ([]string, error) {
+	if !strings.HasPrefix(value, "[") {
+		s, err := parseTOMLString(value)
+		return []string{s}, err
+	}
+	if !strings.HasSuffix(value, "]") {
+		return nil, fmt.Errorf("unterminated array %s", value)
+	}
+	return splitTOMLArray(value[1 : len(value)-1]), nil
+}
+
+// stripTOMLComment removes a trailing comment that isn't inside a string
+func stripTOMLComment(value string) string {
+	var quote byte
+	for i := 0; i < len(value); i++ {
+		switch {
+		case quote != 0:
+			if value[i] == '\\' && quote == '"' {
+				i++
+			} else if value[i] == quote {
+				quote = 0
+			}
+		case value[i] == '"' || value[i] == '\'':
+			quote = value[i]
+		case value[i] == '#':
+			return strings.TrimSpace(value[:i])
+		}
+	}
+	return strings.TrimSpace(value)
+}
+
+// convertDateFormat turns a format like "YYYY-MM-DD" into a Go time layout.
+// Go layouts (e.g. "2006-01-02") are left as they are.
+func convertDateFormat(format string) string {
+	return strings.NewReplacer(
+		"YYYY", "2006",
+		"YY", "06",
+		"MM", "01",
+		"DD", "02",
+		"HH", "15",
+		"mm", "04",
+		"ss", "05",
+	).Replace(format)
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Number of resolved files listed by EXPLAIN before the rest is summarized
+const explainMaxFiles = 20
+
+// Descriptions of what every query type returns
+var queryTypeDescriptions = map[QueryType]string{
+	LIST:          "one result per file",
+	TABLE:         "one row per file with a File column",
+	TABLE_NO_ID:   "one row per file",
+	TASK:          "task list items",
+	PARAGRAPH:     "paragraphs",
+	ORDEREDLIST:   "ordered list items",
+	UNORDEREDLIST: "unordered list items",
+	FENCEDCODE:    "fenced code blocks",
+	HEADING:       "headings",
+	SECTION:       "sections under a heading",
+	BLOCKQUOTE:    "blockquotes",
+	CALLOUT:       "callouts",
+	LINK:          "links",
+	IMAGE:         "images",
+	MDTABLE:       "rows of markdown tables",
+}
+
+// Fields that every item of a query type has besides the file's metadata
+var itemFieldsByType = map[QueryType][]string{
+	HEADING:    {"level", "heading"},
+	SECTION:    {"level", "heading"},
+	FENCEDCODE: {"lang", "info", "fence", "attr."},
+	LINK:       {"text", "target", "link-title", "link-type", "internal"},
+	IMAGE:      {"text", "target", "link-title", "link-type", "internal"},
+	BLOCKQUOTE: {"callout."},
+	CALLOUT:    {"callout."},
+	MDTABLE:    {"table."},
+}
+
+// explainQuery describes how a query is executed: the files it reads, where
+// its filters apply, how results are sorted, grouped and limited, and a rough
+// estimate of the work involved
+func explainQuery(ast *QueryNode) (string, error) {
+	var plan strings.Builder
+
+	fmt.Fprintf(&plan, "Query type: %s (%s)\n", ast.Type, queryTypeDescriptions[ast.Type])
+	if ast.Type == SECTION {
+		direct := ""
+		if ast.SectionDirect {
+			direct = ", without subsections"
+		}
+		fmt.Fprintf(&plan, "Section: %q%s\n", ast.Section, direct)
+	}
+
+	files := explainSources(&plan, ast)
+	explainFilters(&plan, ast)
+	explainDistinct(&plan, ast)
+	if len(ast.Stages) > 0 {
+		explainStages(&plan, ast)
+	} else {
+		explainSort(&plan, ast)
+	}
+	explainGroupAndLimit(&plan, ast)
+	explainCost(&plan, ast, files)
+
+	return strings.TrimSuffix(plan.String(), "\n"), nil
+}
+
+// explainSources lists the sources of the query and the files they resolve
+// to, and returns those files
+func explainSources(plan *strings.Builder, ast *QueryNode) []string {
+	sources := ast.From
+	plan.WriteString("Sources:\n")
+	if len(sources) == 0 {
+		sources = config.From
+		if len(sources) == 0 {
+			plan.WriteString("  none: no FROM clause and no default sources in the configuration\n")
+		} else {
+			plan.WriteString("  no FROM clause, using the default sources of the configuration\n")
+		}
+	}
+	if vaultRoot != "" {
+		fmt.Fprintf(plan, "  resolved against the vault root %s\n", vaultRoot)
+	}
+
+	var files []string
+	seen := make(map[fileID]bool)
+	for _, source := range sources {
+		resolved := resolvePath(source)
+
+		kind := "file"
+		if hasGlobMeta(resolved) {
+			kind = "glob pattern"
+		} else if fileInfo, err := os.Stat(resolved); err != nil {
+			kind = "missing"
+		} else if fileInfo.IsDir() {
+			kind = "directory, walked recursively"
+		}
+
+		sourceFiles, err := collectMarkdownFiles([]string{source}, ast.Exclude)
+		if err != nil {
+			fmt.Fprintf(plan, "  %q (%s): error: %v\n", source, kind, err)
+			continue
+		}
+		// Files already reached through an earlier source are only parsed once
+		duplicates := 0
+		for _, file := range sourceFiles {
+			if id := fileIdentity(file); seen[id] {
+				duplicates++
+			} else {
+				seen[id] = true
+				files = append(files, file)
+			}
+		}
+		if duplicates > 0 {
+			fmt.Fprintf(plan, "  %q (%s): %d files, %d of them already in an earlier source\n", source, kind, len(sourceFiles), duplicates)
+		} else {
+			fmt.Fprintf(plan, "  %q (%s): %d files\n", source, kind, len(sourceFiles))
+		}
+	}
+
+	var excluded []string
+	for _, pattern := range ast.Exclude {
+		excluded = append(excluded, fmt.Sprintf("%q", pattern))
+	}
+	for _, pattern := range config.Ignore {
+		excluded = append(excluded, fmt.Sprintf("%q (configuration)", pattern))
+	}
+	if len(excluded) > 0 {
+		fmt.Fprintf(plan, "Excluded: %s\n", strings.Join(excluded, ", "))
+	}
+
+	walked := fmt.Sprintf("Directories: only %s files", strings.Join(markdownExtensions(), ", "))
+	if !noIgnoreFlag {
+		walked += ", skipping the ones ignored by .gitignore and .dynomarkignore"
+	}
+	plan.WriteString(walked + "\n")
+
+	fmt.Fprintf(plan, "Files (%d):\n", len(files))
+	for i, file := range files {
+		if i == explainMaxFiles {
+			fmt.Fprintf(plan, "  ... and %d more\n", len(files)-explainMaxFiles)
+			break
+		}
+		fmt.Fprintf(plan, "  %s\n", relativePath(file))
+	}
+
+	return files
+}
+
+// explainFilters lists the IN SECTION and WHERE clauses with what they're
+// checked for. Every condition is checked for every item, conditions on the
+// metadata of a file are only marked as having the same result for all the
+// items of that file.
+func explainFilters(plan *strings.Builder, ast *QueryNode) {
+	var filters []string
+
+	if ast.InSection != "" {
+		filter := fmt.Sprintf("IN SECTION %q", ast.InSection)
+		if isFileQuery(ast.Type) {
+			filters = append(filters, filter+" (checked for every file, files without the heading are skipped)")
+		} else {
+			filters = append(filters, filter+" (checked for every item, only items under the heading)")
+		}
+	}
+
+	clauses := ast.Where.clauses()
+	leftToRight := false
+	for _, clause := range clauses {
+		leftToRight = leftToRight || len(clause.Conditions) > 1
+		for i, condition := range clause.Conditions {
+			filter := formatCondition(condition)
+			if i > 0 {
+				filter = condition.LogicalOp + " " + filter
+			} else if len(clauses) > 1 {
+				filter = "WHERE " + filter
+			}
+			switch {
+			case isFileQuery(ast.Type):
+				filter += " (checked for every file)"
+			case isFileCondition(ast.Type, condition):
+				filter += " (checked for every item, same result for all the items of a file)"
+			default:
+				filter += " (checked for every item)"
+			}
+			filters = append(filters, filter)
+		}
+	}
+
+	plan.WriteString("Filters:\n")
+	if len(filters) == 0 {
+		plan.WriteString("  none\n")
+		return
+	}
+	for _, filter := range filters {
+		fmt.Fprintf(plan, "  %s\n", filter)
+	}
+	if leftToRight {
+		plan.WriteString("  WHERE conditions are evaluated left to right for every result\n")
+	}
+	if len(clauses) > 1 {
+		plan.WriteString("  every WHERE clause has to match\n")
+	}
+}
+
+// explainDistinct describes how DISTINCT compares results
+func explainDistinct(plan *strings.Builder, ast *QueryNode) {
+	switch {
+	case !ast.Distinct:
+	case ast.DistinctField != "":
+		fmt.Fprintf(plan, "Distinct: by [%s], the first result with every value is kept, results without the field count as one value\n", ast.DistinctField)
+	case ast.Type == TABLE || ast.Type == TABLE_NO_ID:
+		plan.WriteString("Distinct: by all the cells of a row, the first row with every value is kept\n")
+	default:
+		plan.WriteString("Distinct: by result text, the first result with every text is kept\n")
+	}
+}
+
+// isFileQuery checks if a query type returns one result per file
+func isFileQuery(queryType QueryType) bool {
+	return queryType == LIST || queryType == TABLE || queryType == TABLE_NO_ID
+}
+
+// isFileCondition checks if a condition only depends on the metadata of a
+// file (frontmatter, inline fields and file.* fields), so it has the same
+// result for every item of that file
+func isFileCondition(queryType QueryType, condition ConditionNode) bool {
+	if !condition.IsMetadata || condition.Function == "CHECKED" {
+		return false
+	}
+
+	field := condition.Field
+	if strings.HasPrefix(field, "file.") {
+		return true
+	}
+	if strings.HasPrefix(field, "item.") {
+		return false
+	}
+	// Columns of markdown tables can have any name
+	if queryType == MDTABLE {
+		return false
+	}
+	for _, itemField := range itemFieldsByType[queryType] {
+		if field == itemField || (strings.HasSuffix(itemField, ".") && strings.HasPrefix(field, itemField)) {
+			return false
+		}
+	}
+	return true
+}
+
+// formatCondition writes a condition the way it's written in a query
+func formatCondition(condition ConditionNode) string {
+	var parts []string
+	if condition.IsNegated {
+		parts = append(parts, "NOT")
+	}
+	if condition.IsMetadata {
+		parts = append(parts, "["+condition.Field+"]")
+	}
+	parts = append(parts, condition.Function)
+	if isNumber(condition.Value) {
+		parts = append(parts, condition.Value)
+	} else if condition.Function != "CHECKED" {
+		parts = append(parts, fmt.Sprintf("%q", condition.Value))
+	}
+	return strings.Join(parts, " ")
+}
+
+// explainSort describes the order of the results
+func explainSort(plan *strings.Builder, ast *QueryNode) {
+	if len(ast.Sorts) == 0 {
+		if isFileQuery(ast.Type) {
+			plan.WriteString("Sort: none, files in the order they're found\n")
+		} else {
+			plan.WriteString("Sort: none, files in the order they're found and items in document order\n")
+		}
+		return
+	}
+
+	if ast.Type != TABLE && ast.Type != TABLE_NO_ID && ast.Type != MDTABLE {
+		fmt.Fprintf(plan, "Sort: result text in natural order, %s\n", ast.Sorts[0].SortDirection)
+		return
+	}
+
+	plan.WriteString("Sort:\n")
+	for _, sortNode := range ast.Sorts {
+		plan.WriteString("  " + describeSortKey(ast, sortNode) + "\n")
+	}
+}
+
+// describeSortKey describes a field a TABLE or MDTABLE query is sorted by
+func describeSortKey(ast *QueryNode, sortNode SortNode) string {
+	key := fmt.Sprintf("[%s] %s", sortNode.Metadata, sortNode.SortDirection)
+	if ast.Type != MDTABLE && !isSortColumn(ast, sortNode) {
+		key += " (ignored, TABLE queries can only sort by their columns)"
+	}
+	return key
+}
+
+// isSortColumn checks if a TABLE query sorts by one of its columns
+func isSortColumn(ast *QueryNode, sortNode SortNode) bool {
+	return slices.ContainsFunc(ast.Columns, func(column ColumnDefinition) bool {
+		return column.Name == sortNode.Metadata
+	}) || (ast.Type == TABLE && sortNode.Metadata == "File")
+}
+
+// explainStages describes the SORT, OFFSET and LIMIT stages of a query that
+// has more than one of them
+func explainStages(plan *strings.Builder, ast *QueryNode) {
+	isTable := ast.Type == TABLE || ast.Type == TABLE_NO_ID
+	stages := ast.stages()
+
+	plan.WriteString("Sort and limit stages, applied in order:\n")
+	for i, stage := range stages {
+		var steps []string
+		if len(stage.Sorts) > 0 {
+			if ast.Type != TABLE && ast.Type != TABLE_NO_ID && ast.Type != MDTABLE {
+				steps = append(steps, "sort by result text in natural order, "+stage.Sorts[0].SortDirection)
+			} else {
+				var keys []string
+				for _, sortNode := range stage.Sorts {
+					keys = append(keys, describeSortKey(ast, sortNode))
+				}
+				steps = append(steps, "sort by "+strings.Join(keys, ", "))
+			}
+		}
+
+		// The OFFSET and LIMIT of the last stage apply to every group
+		perGroup := i == len(stages)-1 && ast.GroupBy != "" && !isTable
+		if stage.Offset > 0 {
+			if perGroup {
+				steps = append(steps, fmt.Sprintf("skip the first %d results of every group", stage.Offset))
+			} else {
+				steps = append(steps, fmt.Sprintf("skip the first %d results", stage.Offset))
+			}
+		}
+		if stage.Limit >= 0 {
+			if perGroup {
+				steps = append(steps, fmt.Sprintf("%d results in every group", stage.Limit))
+			} else {
+				steps = append(steps, fmt.Sprintf("keep the first %d results", stage.Limit))
+			}
+		}
+		fmt.Fprintf(plan, "  %d. %s\n", i+1, strings.Join(steps, ", then "))
+	}
+}
+
+// explainGroupAndLimit describes GROUP BY, OFFSET and LIMIT
+func explainGroupAndLimit(plan *strings.Builder, ast *QueryNode) {
+	isTable := ast.Type == TABLE || ast.Type == TABLE_NO_ID
+
+	switch {
+	case ast.GroupBy == "":
+		plan.WriteString("Group: none\n")
+	case isTable:
+		fmt.Fprintf(plan, "Group: [%s] (ignored by TABLE queries)\n", ast.GroupBy)
+	default:
+		group := fmt.Sprintf("Group: by [%s], groups in natural order, results without the field under \"Unknown\"", ast.GroupBy)
+		if ast.GroupLimit > 0 {
+			group += fmt.Sprintf(", first %d groups", ast.GroupLimit)
+		}
+		plan.WriteString(group + "\n")
+	}
+
+	grouped := ast.GroupBy != "" && !isTable
+	switch {
+	case len(ast.Stages) > 0:
+		// Limits are part of the stages
+	case ast.Limit < 0 && ast.Offset == 0:
+		plan.WriteString("Limit: none\n")
+	case ast.Limit < 0 && grouped:
+		fmt.Fprintf(plan, "Limit: none, skipping the first %d results of every group\n", ast.Offset)
+	case ast.Limit < 0:
+		fmt.Fprintf(plan, "Limit: none, skipping the first %d results\n", ast.Offset)
+	case grouped && ast.Offset > 0:
+		fmt.Fprintf(plan, "Limit: %d results in every group after skipping its first %d\n", ast.Limit, ast.Offset)
+	case grouped:
+		fmt.Fprintf(plan, "Limit: %d results in every group\n", ast.Limit)
+	case ast.Offset > 0:
+		fmt.Fprintf(plan, "Limit: %d results after filtering, sorting and skipping the first %d\n", ast.Limit, ast.Offset)
+	default:
+		fmt.Fprintf(plan, "Limit: first %d results after filtering and sorting\n", ast.Limit)
+	}
+}
+
+// explainCost estimates the work of running the query from the size of the
+// files and how much of them has to be parsed
+func explainCost(plan *strings.Builder, ast *QueryNode, files []string) {
+	var size int64
+	for _, file := range files {
+		if fileInfo, err := os.Stat(file); err == nil {
+			size += fileInfo.Size()
+		}
+	}
+
+	parsing := "every file is parsed into a block tree"
+	if isFileQuery(ast.Type) {
+		parsing = "only the metadata of every file is read"
+		if ast.InSection != "" {
+			parsing += ", and its headings for IN SECTION"
+		}
+	}
+
+	fmt.Fprintf(plan, "Estimated cost: %d files, %s; %s\n", len(files), formatSize(size), parsing)
+}
+
+// formatSize formats a number of bytes in a human readable way
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", size)
+}
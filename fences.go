@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CodeFence is the opening fence of a fenced code block (e.g. "```go")
+type CodeFence struct {
+	Char   byte // '`' or '~'
+	Length int
+	Indent int
+	Info   string
+}
+
+// parseFenceOpening checks if the line opens a fenced code block. Fences are
+// at least 3 backticks or tildes indented by up to 3 spaces, and the info
+// string of a backtick fence can't contain backticks.
+func parseFenceOpening(line string) (CodeFence, bool) {
+	indent := indentWidth(line)
+	if indent > 3 {
+		return CodeFence{}, false
+	}
+
+	trimmedLine := strings.TrimLeft(line, " \t")
+	if trimmedLine == "" || (trimmedLine[0] != '`' && trimmedLine[0] != '~') {
+		return CodeFence{}, false
+	}
+
+	char := trimmedLine[0]
+	length := 0
+	for length < len(trimmedLine) && trimmedLine[length] == char {
+		length++
+	}
+	if length < 3 {
+		return CodeFence{}, false
+	}
+
+	info := strings.TrimSpace(trimmedLine[length:])
+	if char == '`' && strings.Contains(info, "`") {
+		return CodeFence{}, false
+	}
+
+	return CodeFence{Char: char, Length: length, Indent: indent, Info: info}, true
+}
+
+// String returns the opening fence as it should be written
+func (f CodeFence) String() string {
+	fence := strings.Repeat(string(f.Char), f.Length)
+	if f.Info != "" {
+		return fence + f.Info
+	}
+	return fence
+}
+
+// parseFencedCode returns the content of every fenced code block, including
+// the ones nested in lists and blockquotes. Indented code blocks are ignored.
+func parseFencedCode(doc *Document) []ContentItem {
+	var fencedCode []ContentItem
+
+	walkBlocks(doc.Root, func(block *Block) bool {
+		if block.Type != BLOCK_CODE || block.Fence == nil {
+			return true
+		}
+
+		fence := *block.Fence
+		lang, attributes := parseInfoString(fence.Info)
+		fields := Metadata{
+			"lang":  lang,
+			"info":  fence.Info,
+			"fence": fence.String(),
+		}
+		for key, value := range attributes {
+			fields["attr."+key] = value
+		}
+
+		fencedCode = append(fencedCode, ContentItem{
+			Text:    strings.Join(block.Content, "\n"),
+			Line:    block.StartLine,
+			EndLine: block.EndLine,
+			Fields:  fields,
+		})
+		return true
+	})
+
+	return fencedCode
+}
+
+// renderFencedCode wraps FENCEDCODE results in their original fences
+func renderFencedCode(content []string, metadataList []Metadata) []string {
+	rendered := make([]string, len(content))
+	for i, code := range content {
+		opening, ok := parseFenceOpening(fmt.Sprintf("%v", metadataList[i]["fence"]))
+		if !ok {
+			opening = CodeFence{Char: '`', Length: 3}
+		}
+		fence := opening.String()
+		closing := strings.Repeat(string(opening.Char), opening.Length)
+		if code == "" {
+			rendered[i] = fence + "\n" + closing
+		} else {
+			rendered[i] = fence + "\n" + code + "\n" + closing
+		}
+	}
+	return rendered
+}
+
+// parseInfoString splits the info string of a code fence into the language
+// and its attributes. Both "go file=main.go" and "{.go file="main.go"}"
+// styles are supported; attributes without a value are set to "true".
+func parseInfoString(info string) (string, map[string]string) {
+	attributes := make(map[string]string)
+	lang := ""
+
+	info = strings.TrimSpace(info)
+	if info != "" && info[0] != '{' {
+		end := strings.IndexAny(info, " \t{")
+		if end == -1 {
+			end = len(info)
+		}
+		lang = info[:end]
+		info = info[end:]
+	}
+
+	info = strings.TrimSpace(info)
+	info = strings.TrimSuffix(strings.TrimPrefix(info, "{"), "}")
+
+	for i, word := range splitQuotedFields(info) {
+		switch {
+		case i == 0 && lang == "" && !strings.ContainsAny(word, ".#="):
+			// Quarto and R Markdown chunks put the language first ({python})
+			lang = word
+		case strings.HasPrefix(word, "."):
+			if lang == "" {
+				lang = word[1:]
+			} else if attributes["class"] == "" {
+				attributes["class"] = word[1:]
+			} else {
+				attributes["class"] += " " + word[1:]
+			}
+		case strings.HasPrefix(word, "#") && len(word) > 1:
+			attributes["id"] = word[1:]
+		case strings.Contains(word, "="):
+			key, value, _ := strings.Cut(word, "=")
+			attributes[strings.ToLower(key)] = strings.Trim(value, `"'`)
+		default:
+			attributes[strings.ToLower(word)] = "true"
+		}
+	}
+
+	return lang, attributes
+}
+
+// splitQuotedFields splits a string on whitespace, keeping quoted values
+// (e.g. title="My file") together
+func splitQuotedFields(s string) []string {
+	var fields []string
+	var current strings.Builder
+	var quote rune
+
+	for _, char := range s {
+		switch {
+		case quote != 0:
+			current.WriteRune(char)
+			if char == quote {
+				quote = 0
+			}
+		case char == '"' || char == '\'':
+			quote = char
+			current.WriteRune(char)
+		case char == ' ' || char == '\t':
+			if current.Len() > 0 {
+				fields = append(fields, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(char)
+		}
+	}
+	if current.Len() > 0 {
+		fields = append(fields, current.String())
+	}
+
+	return fields
+}
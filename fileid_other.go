@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package main
+
+// Without inodes, files are identified by their absolute path with the
+// symlinks resolved
+func fileIdentity(path string) fileID {
+	return fileID{Path: canonicalPath(path)}
+}
@@ -0,0 +1,23 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileIdentity returns what identifies a file on disk: its device and inode,
+// so the same file reached through a symlink, a hard link or another path is
+// recognized
+func fileIdentity(path string) fileID {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return fileID{Path: canonicalPath(path)}
+	}
+	stat, ok := fileInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{Path: canonicalPath(path)}
+	}
+	return fileID{Device: uint64(stat.Dev), Inode: uint64(stat.Ino)}
+}
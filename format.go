@@ -0,0 +1,338 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Language of the fenced code blocks holding queries in markdown files
+const queryBlockLang = "dynomark"
+
+// queryBlock is a query inside a ```dynomark block of a markdown file
+type queryBlock struct {
+	Line   int    // Index of the first line of the query
+	Lines  int    // Number of lines of the query
+	Prefix string // Indentation and blockquote markers before every line
+	Query  string
+}
+
+// findQueryBlocks returns the queries of the ```dynomark blocks in a file
+func findQueryBlocks(lines []string) []queryBlock {
+	_, offset := parseFrontmatter(lines)
+	doc := parseDocument(lines[offset:])
+
+	var blocks []queryBlock
+	walkBlocks(doc.Root, func(block *Block) bool {
+		if block.Type != BLOCK_CODE || block.Fence == nil || len(block.Content) == 0 {
+			return true
+		}
+		if lang, _ := parseInfoString(block.Fence.Info); lang != queryBlockLang {
+			return true
+		}
+
+		query := strings.TrimSpace(strings.Join(block.Content, "\n"))
+		if query == "" {
+			return true
+		}
+
+		line := offset + block.StartLine + 1
+		prefix := ""
+		if first := lines[line]; strings.HasSuffix(first, block.Content[0]) {
+			prefix = first[:len(first)-len(block.Content[0])]
+		}
+
+		blocks = append(blocks, queryBlock{Line: line, Lines: len(block.Content), Prefix: prefix, Query: query})
+		return true
+	})
+	return blocks
+}
+
+// formatQuery rewrites a query in its canonical form: uppercase keywords,
+// clauses in the order FROM, EXCLUDE, IN SECTION, WHERE, DISTINCT, SORT,
+// GROUP BY, LIMIT, OFFSET (SORT, LIMIT and OFFSET stages keep their order),
+// quoted paths and values and SORT directions written out. Variables are
+// kept as they are.
+func formatQuery(query string) (string, error) {
+	ast, err := parseUnbound(Lex(query))
+	if err != nil {
+		return "", err
+	}
+	return formatAST(ast), nil
+}
+
+// parseUnbound parses a query without binding its variables. They're parsed
+// as strings carrying their name, so they can be written back instead of
+// their values.
+func parseUnbound(tokens []Token) (*QueryNode, error) {
+	unbound := make([]Token, len(tokens))
+	for i, token := range tokens {
+		unbound[i] = token
+		if token.Type == TOKEN_VARIABLE {
+			unbound[i] = Token{Type: TOKEN_STRING, Value: variableMarker + token.Value}
+		}
+	}
+	return Parse(unbound)
+}
+
+// formatAST writes a parsed query in its canonical form
+func formatAST(ast *QueryNode) string {
+	var parts []string
+	if ast.Explain {
+		parts = append(parts, "EXPLAIN")
+	}
+
+	switch ast.Type {
+	case TABLE, TABLE_NO_ID:
+		table := "TABLE"
+		if ast.Type == TABLE_NO_ID {
+			table = "TABLE NO ID"
+		}
+		var columns []string
+		for _, column := range ast.Columns {
+			if column.Alias != column.Name {
+				columns = append(columns, fmt.Sprintf("%s AS %s", column.Name, formatValue(column.Alias)))
+			} else {
+				columns = append(columns, column.Name)
+			}
+		}
+		if len(columns) > 0 {
+			table += " " + strings.Join(columns, ", ")
+		}
+		parts = append(parts, table)
+	case SECTION:
+		section := "SECTION"
+		if ast.SectionDirect {
+			section += " DIRECT"
+		}
+		parts = append(parts, section+" "+formatValue(ast.Section))
+	default:
+		parts = append(parts, string(ast.Type))
+	}
+
+	if len(ast.From) > 0 {
+		parts = append(parts, "FROM "+formatValues(ast.From))
+	}
+	if len(ast.Exclude) > 0 {
+		parts = append(parts, "EXCLUDE "+formatValues(ast.Exclude))
+	}
+	if ast.InSection != "" {
+		parts = append(parts, "IN SECTION "+formatValue(ast.InSection))
+	}
+
+	for _, clause := range ast.Where.clauses() {
+		where := []string{"WHERE"}
+		for i, condition := range clause.Conditions {
+			if i > 0 {
+				where = append(where, condition.LogicalOp)
+			}
+			if condition.IsNegated {
+				where = append(where, "NOT")
+			}
+			if condition.IsMetadata {
+				where = append(where, "["+condition.Field+"]")
+			}
+			where = append(where, condition.Function)
+			if condition.Function == "CHECKED" {
+				continue
+			}
+			if isNumber(condition.Value) && condition.Function != "IS" && condition.Function != "CONTAINS" {
+				where = append(where, condition.Value)
+			} else {
+				where = append(where, formatWhereValue(condition.Value))
+			}
+		}
+		parts = append(parts, strings.Join(where, " "))
+	}
+
+	if ast.Distinct {
+		distinct := "DISTINCT"
+		if ast.DistinctField != "" {
+			distinct += fmt.Sprintf(" [%s]", ast.DistinctField)
+		}
+		parts = append(parts, distinct)
+	}
+
+	group := ""
+	if ast.GroupBy != "" {
+		group = "GROUP BY"
+		if ast.GroupLimit > 0 {
+			group += fmt.Sprintf(" %d", ast.GroupLimit)
+		}
+		group += fmt.Sprintf(" [%s]", ast.GroupBy)
+	}
+
+	// GROUP BY goes before the LIMIT and OFFSET of the last stage, which
+	// apply to every group
+	stages := ast.stages()
+	for i, stage := range stages {
+		if len(stage.Sorts) > 0 {
+			var sorts []string
+			for _, sortNode := range stage.Sorts {
+				if sortNode.Metadata != "" {
+					sorts = append(sorts, fmt.Sprintf("[%s] %s", sortNode.Metadata, sortNode.SortDirection))
+				} else {
+					sorts = append(sorts, sortNode.SortDirection)
+				}
+			}
+			parts = append(parts, "SORT "+strings.Join(sorts, ", "))
+		}
+		if i == len(stages)-1 && group != "" {
+			parts = append(parts, group)
+		}
+		if stage.Limit >= 0 {
+			parts = append(parts, fmt.Sprintf("LIMIT %d", stage.Limit))
+		}
+		if stage.Offset > 0 {
+			parts = append(parts, fmt.Sprintf("OFFSET %d", stage.Offset))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Prefix of the strings standing in for variables while formatting
+const variableMarker = "\x00"
+
+// formatValue quotes a string, or writes a variable back
+func formatValue(value string) string {
+	if name, ok := strings.CutPrefix(value, variableMarker); ok {
+		if name == "this.file" {
+			return "[[#]]"
+		}
+		return "$" + name
+	}
+	return `"` + value + `"`
+}
+
+// formatWhereValue is formatValue for WHERE values, where fields of the
+// context file are written without a $ (this.project)
+func formatWhereValue(value string) string {
+	if name, ok := strings.CutPrefix(value, variableMarker); ok && strings.HasPrefix(name, "this.") {
+		return name
+	}
+	return formatValue(value)
+}
+
+func formatValues(values []string) string {
+	formatted := make([]string, len(values))
+	for i, value := range values {
+		formatted[i] = formatValue(value)
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// formatQueryBlocks formats the queries of the ```dynomark blocks in a file
+// and returns the new lines of the file. Blocks with queries that can't be
+// parsed are left as they are and reported as errors.
+func formatQueryBlocks(lines []string) ([]string, []error) {
+	var errs []error
+	formatted := append([]string{}, lines...)
+
+	// Replace from the bottom up so line numbers stay valid
+	blocks := findQueryBlocks(lines)
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block := blocks[i]
+		query, err := formatQuery(block.Query)
+		if err != nil {
+			errs = append([]error{fmt.Errorf("line %d: %w", block.Line+1, err)}, errs...)
+			continue
+		}
+		replacement := []string{block.Prefix + query}
+		formatted = append(formatted[:block.Line], append(replacement, formatted[block.Line+block.Lines:]...)...)
+	}
+
+	return formatted, errs
+}
+
+// runFmt runs the "fmt" subcommand. It formats the query given with -q (or
+// read from stdin), or the query blocks of markdown files and directories.
+func runFmt(args []string) error {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "write the formatted files instead of printing them")
+	list := flags.Bool("l", false, "only list the files whose formatting differs")
+	var query string
+	flags.StringVar(&query, "query", "", "query to format")
+	flags.StringVar(&query, "q", "", "query to format (shorthand)")
+	flags.StringVar(&rootFlag, "root", "", "root directory of the vault that paths are resolved against")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: dynomark fmt [flags] [-q query | path...]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if query == "" && flags.NArg() == 0 {
+		stat, _ := os.Stdin.Stat()
+		if (stat.Mode() & os.ModeCharDevice) != 0 {
+			flags.Usage()
+			return fmt.Errorf("no query or files to format")
+		}
+		input, err := readFromPipe()
+		if err != nil {
+			return err
+		}
+		query = input
+	}
+
+	if query != "" {
+		formatted, err := formatQuery(query)
+		if err != nil {
+			return err
+		}
+		fmt.Println(formatted)
+		return nil
+	}
+
+	if err := resolveVaultRoot(rootFlag); err != nil {
+		return err
+	}
+	files, err := collectMarkdownFiles(flags.Args(), nil)
+	if err != nil {
+		return err
+	}
+
+	failed := false
+	for _, path := range files {
+		lines, err := readMarkdownFile(path)
+		if err != nil {
+			return err
+		}
+
+		formatted, errs := formatQueryBlocks(lines)
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			failed = true
+		}
+
+		changed := strings.Join(formatted, "\n") != strings.Join(lines, "\n")
+		switch {
+		case *list:
+			if changed {
+				fmt.Println(path)
+			}
+		case *write:
+			if changed {
+				if err := writeLines(path, formatted); err != nil {
+					return err
+				}
+			}
+		default:
+			fmt.Println(strings.Join(formatted, "\n"))
+		}
+	}
+
+	if failed {
+		return fmt.Errorf("some queries couldn't be formatted")
+	}
+	return nil
+}
+
+// writeLines replaces the content of a file, keeping its permissions
+func writeLines(path string, lines []string) error {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), fileInfo.Mode().Perm())
+}
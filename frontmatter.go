@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseFrontmatter parses the frontmatter at the very top of a document and
+// returns its metadata together with the number of lines it takes up. YAML
+// (between "---" and "---" or "..."), TOML (between "+++" lines) and JSON
+// (a single object) frontmatter is supported. A "---" anywhere else is a
+// thematic break, so a document without a closing delimiter has no
+// frontmatter at all.
+func parseFrontmatter(lines []string) (Metadata, int) {
+	if len(lines) == 0 {
+		return nil, 0
+	}
+
+	switch strings.TrimRight(lines[0], " \t") {
+	case "---":
+		for i := 1; i < len(lines); i++ {
+			closing := strings.TrimRight(lines[i], " \t")
+			if closing == "---" || closing == "..." {
+				return parseYAMLFrontmatter(lines[1:i]), i + 1
+			}
+		}
+	case "+++":
+		for i := 1; i < len(lines); i++ {
+			if strings.TrimRight(lines[i], " \t") == "+++" {
+				return parseTOMLFrontmatter(lines[1:i]), i + 1
+			}
+		}
+	case "{":
+		// The object ends at the first line that makes it valid JSON
+		for i := 1; i < len(lines); i++ {
+			if !strings.HasPrefix(strings.TrimSpace(lines[i]), "}") {
+				continue
+			}
+			data := []byte(strings.Join(lines[:i+1], "\n"))
+			if json.Valid(data) {
+				return parseJSONFrontmatter(data), i + 1
+			}
+		}
+	}
+
+	return nil, 0
+}
+
+// parseYAMLFrontmatter reads the "key: value" pairs of YAML frontmatter.
+// Nested keys are read as if they were top level keys.
+func parseYAMLFrontmatter(lines []string) Metadata {
+	metadata := make(Metadata)
+	for _, line := range lines {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		metadata[key] = parseFrontmatterValue(value)
+	}
+	return metadata
+}
+
+// parseTOMLFrontmatter reads the "key = value" pairs of TOML frontmatter.
+// Keys under a [table] header are prefixed with the table name (e.g.
+// "params.author").
+func parseTOMLFrontmatter(lines []string) Metadata {
+	metadata := make(Metadata)
+	table := ""
+
+	for _, line := range lines {
+		line = stripTOMLComment(line)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			table = strings.ToLower(strings.Trim(line, "[] ")) + "."
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = table + strings.ToLower(strings.Trim(strings.TrimSpace(key), `"'`))
+		value = strings.TrimSpace(value)
+
+		if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+			metadata[key] = strings.Join(splitTOMLArray(value[1:len(value)-1]), ", ")
+			continue
+		}
+
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			metadata[key] = value[1 : len(value)-1]
+			continue
+		}
+
+		metadata[key] = parseFrontmatterValue(value)
+	}
+
+	return metadata
+}
+
+// splitTOMLArray splits the inside of a TOML array into unquoted values.
+// Whitespace is only kept inside quotes.
+func splitTOMLArray(s string) []string {
+	var items []string
+	var current strings.Builder
+	var quote byte
+	quoted := false
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			} else {
+				current.WriteByte(s[i])
+			}
+		case s[i] == '"' || s[i] == '\'':
+			quote = s[i]
+			quoted = true
+		case s[i] == ',':
+			items = append(items, current.String())
+			current.Reset()
+			quoted = false
+		case s[i] == ' ' || s[i] == '\t':
+		default:
+			current.WriteByte(s[i])
+		}
+	}
+	if current.Len() > 0 || quoted {
+		items = append(items, current.String())
+	}
+
+	return items
+}
+
+// parseJSONFrontmatter reads the fields of JSON frontmatter. Nested objects
+// are flattened into dotted keys and arrays are joined with commas.
+func parseJSONFrontmatter(data []byte) Metadata {
+	var object map[string]any
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil
+	}
+
+	metadata := make(Metadata)
+	var flatten func(prefix string, object map[string]any)
+	flatten = func(prefix string, object map[string]any) {
+		for key, value := range object {
+			key = prefix + strings.ToLower(key)
+			switch value := value.(type) {
+			case map[string]any:
+				flatten(key+".", value)
+			case []any:
+				items := make([]string, len(value))
+				for i, item := range value {
+					items[i] = fmt.Sprintf("%v", item)
+				}
+				metadata[key] = strings.Join(items, ", ")
+			case float64:
+				if value == float64(int(value)) {
+					metadata[key] = int(value)
+				} else {
+					metadata[key] = value
+				}
+			case nil:
+				metadata[key] = ""
+			default:
+				metadata[key] = value
+			}
+		}
+	}
+	flatten("", object)
+
+	return metadata
+}
+
+// parseFrontmatterValue turns numbers and booleans into their Go values and
+// keeps everything else as a string
+func parseFrontmatterValue(value string) any {
+	if i, err := strconv.Atoi(value); err == nil {
+		return i
+	}
+	if b, err := strconv.ParseBool(value); err == nil {
+		return b
+	}
+	return value
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Heading is a single ATX (# Title) or setext (Title + =====) heading
+type Heading struct {
+	Line    int // Index of the first line of the heading
+	EndLine int // Index of the last line of the heading (setext underline)
+	Level   int
+	Text    string
+}
+
+// parseHeadings returns the top level headings of the document. Headings
+// nested in lists or blockquotes don't start a section.
+func parseHeadings(doc *Document) []Heading {
+	var headings []Heading
+	for _, block := range doc.Root.Children {
+		if block.Type == BLOCK_HEADING {
+			headings = append(headings, Heading{
+				Line:    block.StartLine,
+				EndLine: block.EndLine,
+				Level:   block.Level,
+				Text:    block.Text,
+			})
+		}
+	}
+	return headings
+}
+
+// indentWidth returns the width of the leading whitespace of a line,
+// counting tabs as 4 columns.
+func indentWidth(line string) int {
+	width := 0
+	for _, char := range line {
+		if char == ' ' {
+			width++
+		} else if char == '\t' {
+			width += 4 - width%4
+		} else {
+			break
+		}
+	}
+	return width
+}
+
+// renderOutline turns HEADING results into a nested list indented by level
+func renderOutline(content []string, metadataList []Metadata) []string {
+	outline := make([]string, len(content))
+	for i, item := range content {
+		level, _ := metadataList[i]["level"].(int)
+		if level < 1 {
+			level = 1
+		}
+		text := strings.TrimSpace(strings.TrimPrefix(item, strings.Repeat("#", level)))
+		outline[i] = strings.Repeat("  ", level-1) + "- " + text
+	}
+	return outline
+}
+
+// parseSections returns the content under every heading matching the given
+// name, up to the next heading of the same or higher level. If direct is set,
+// the content stops at the first subheading.
+func parseSections(doc *Document, headings []Heading, name string, direct bool) []ContentItem {
+	var sections []ContentItem
+	lines := doc.Lines
+
+	for i, heading := range headings {
+		if !headingMatches(heading.Text, name) {
+			continue
+		}
+
+		end := len(lines)
+		for _, next := range headings[i+1:] {
+			if direct || next.Level <= heading.Level {
+				end = next.Line
+				break
+			}
+		}
+
+		body := trimBlankLines(lines[heading.EndLine+1 : end])
+		if len(body) == 0 {
+			continue
+		}
+
+		last := end - 1
+		for strings.TrimSpace(lines[last]) == "" {
+			last--
+		}
+
+		sections = append(sections, ContentItem{
+			Text:    strings.Join(body, "\n"),
+			Line:    heading.EndLine + 1,
+			EndLine: last,
+			Fields:  Metadata{"heading": heading.Text, "level": heading.Level},
+		})
+	}
+
+	return sections
+}
+
+// headingMatches compares a heading to a name case-insensitively, ignoring
+// leading symbols like emojis (e.g. "⚙️ Frontend Development")
+func headingMatches(text string, name string) bool {
+	name = strings.TrimSpace(name)
+	if strings.EqualFold(text, name) {
+		return true
+	}
+
+	stripped := strings.TrimLeftFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.EqualFold(stripped, name)
+}
+
+func trimBlankLines(lines []string) []string {
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// headingPath returns the chain of headings an item on the given line is
+// nested under, from the outermost to the innermost heading
+func headingPath(headings []Heading, line int) []Heading {
+	var path []Heading
+	for _, heading := range headings {
+		if heading.Line >= line {
+			break
+		}
+		for len(path) > 0 && path[len(path)-1].Level >= heading.Level {
+			path = path[:len(path)-1]
+		}
+		path = append(path, heading)
+	}
+	return path
+}
+
+func hasSection(headings []Heading, name string) bool {
+	for _, heading := range headings {
+		if headingMatches(heading.Text, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// applySections adds the item.section and item.heading-path fields to every
+// item and drops the items that aren't under the given section (if any)
+func applySections(items []ContentItem, headings []Heading, section string) []ContentItem {
+	var result []ContentItem
+	for _, item := range items {
+		path := headingPath(headings, item.Line)
+		if section != "" && !hasSection(path, section) {
+			continue
+		}
+
+		if len(path) > 0 {
+			names := make([]string, len(path))
+			for i, heading := range path {
+				names[i] = heading.Text
+			}
+
+			fields := make(Metadata, len(item.Fields)+2)
+			for key, value := range item.Fields {
+				fields[key] = value
+			}
+			fields["item.section"] = path[len(path)-1].Text
+			fields["item.heading-path"] = strings.Join(names, " > ")
+			item.Fields = fields
+		}
+
+		result = append(result, item)
+	}
+	return result
+}
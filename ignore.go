@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Files with gitignore style patterns that are respected while walking
+// directories. .dynomarkignore is for files that should be tracked by git but
+// not queried (e.g. templates).
+var ignoreFileNames = []string{".gitignore", ".dynomarkignore"}
+
+// ignoreRule is a single pattern of an ignore file
+type ignoreRule struct {
+	pattern  string
+	negate   bool // "!pattern" re-includes a previously ignored path
+	dirOnly  bool // "pattern/" only matches directories
+	anchored bool // Patterns with a slash are relative to the ignore file
+}
+
+// ignoreMatcher holds the rules of the ignore files found while walking a
+// directory tree, keyed by the absolute path of the directory they're in
+type ignoreMatcher struct {
+	rules map[string][]ignoreRule
+}
+
+// newIgnoreMatcher creates a matcher for walking root. The ignore files of
+// the parent directories up to the root of the git repository apply too.
+func newIgnoreMatcher(root string) *ignoreMatcher {
+	matcher := &ignoreMatcher{rules: make(map[string][]ignoreRule)}
+
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return matcher
+	}
+
+	var parents []string
+	for dir := filepath.Dir(absRoot); ; dir = filepath.Dir(dir) {
+		parents = append(parents, dir)
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			for _, parent := range parents {
+				matcher.load(parent)
+			}
+			break
+		}
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	matcher.load(absRoot)
+	return matcher
+}
+
+// load reads the ignore files of a directory
+func (m *ignoreMatcher) load(dir string) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+
+	for _, name := range ignoreFileNames {
+		file, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if rule, ok := parseIgnoreRule(scanner.Text()); ok {
+				m.rules[dir] = append(m.rules[dir], rule)
+			}
+		}
+		file.Close()
+	}
+}
+
+// isIgnored checks a path against the rules of the ignore files in its parent
+// directories. Rules of deeper directories take precedence, and within a
+// directory the last matching rule wins.
+func (m *ignoreMatcher) isIgnored(path string, isDir bool) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	if isDir && filepath.Base(absPath) == ".git" {
+		return true
+	}
+
+	var dirs []string
+	for dir := filepath.Dir(absPath); ; dir = filepath.Dir(dir) {
+		if _, ok := m.rules[dir]; ok {
+			dirs = append(dirs, dir)
+		}
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	ignored := false
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rel, err := filepath.Rel(dirs[i], absPath)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+
+		for _, rule := range m.rules[dirs[i]] {
+			if rule.matches(rel, isDir) {
+				ignored = !rule.negate
+			}
+		}
+	}
+
+	return ignored
+}
+
+// parseIgnoreRule parses a line of an ignore file using the gitignore syntax
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	// Trailing spaces are ignored unless they're escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	var rule ignoreRule
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	rule.pattern = line
+	return rule, true
+}
+
+// matches checks the rule against a path relative to the ignore file
+func (r ignoreRule) matches(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.anchored {
+		return matchGlob(r.pattern, rel)
+	}
+	return matchGlob("**/"+r.pattern, rel)
+}
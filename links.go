@@ -0,0 +1,313 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Link is a single link or image reference found in a markdown document
+type Link struct {
+	Raw     string // The link as it was written in the document
+	Text    string // Link text or image alt text
+	Target  string
+	Title   string
+	Type    string // "inline", "reference", "autolink" or "wikilink"
+	IsImage bool
+	Line    int
+}
+
+var (
+	autolinkRegex  = regexp.MustCompile(`^<([a-zA-Z][a-zA-Z0-9+.-]{1,31}:[^\s<>]*|[a-zA-Z0-9.!#$%&'*+/=?^_{|}~-]+@[a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)*)>`)
+	urlSchemeRegex = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*:`)
+)
+
+func parseLinkItems(doc *Document) []ContentItem {
+	return linksToContentItems(parseLinks(doc), false)
+}
+
+func parseImageItems(doc *Document) []ContentItem {
+	return linksToContentItems(parseLinks(doc), true)
+}
+
+func linksToContentItems(links []Link, images bool) []ContentItem {
+	var items []ContentItem
+	for _, link := range links {
+		if link.IsImage != images {
+			continue
+		}
+		items = append(items, ContentItem{
+			Text: link.Raw,
+			Line: link.Line,
+			Fields: Metadata{
+				"text":       link.Text,
+				"target":     link.Target,
+				"link-title": link.Title,
+				"link-type":  link.Type,
+				"internal":   isInternalTarget(link.Target),
+			},
+		})
+	}
+	return items
+}
+
+// isInternalTarget reports if a link points to a file in the vault rather
+// than to an external URL (e.g. "https://...", "mailto:...", "//host/...")
+func isInternalTarget(target string) bool {
+	return !urlSchemeRegex.MatchString(target) && !strings.HasPrefix(target, "//")
+}
+
+// parseLinks returns every inline link, reference-style link, autolink,
+// wikilink and image in the document. Only paragraphs, headings and tables
+// are searched, so links inside code and HTML blocks are ignored.
+func parseLinks(doc *Document) []Link {
+	var inlineLines []int
+	walkBlocks(doc.Root, func(block *Block) bool {
+		switch block.Type {
+		case BLOCK_PARAGRAPH, BLOCK_HEADING, BLOCK_TABLE:
+			for i := block.StartLine; i <= block.EndLine; i++ {
+				inlineLines = append(inlineLines, i)
+			}
+		}
+		return true
+	})
+
+	var links []Link
+	for _, lineIndex := range inlineLines {
+		links = append(links, parseInlineLinks(doc, doc.Lines[lineIndex], lineIndex)...)
+	}
+
+	return links
+}
+
+// parseInlineLinks returns the links and images in a line of text. Images
+// inside the text of a link (like badges, [![alt](badge.svg)](url)) are
+// returned after the link, whose text has their alt text instead of their
+// markup.
+func parseInlineLinks(doc *Document, line string, lineIndex int) []Link {
+	var links []Link
+	// Matching brackets of the line, found once when they're first needed so
+	// that text which turns out not to be a link isn't scanned over and over
+	var brackets, parens []int
+	lastWikiEnd := strings.LastIndex(line, "]]")
+	i := 0
+	for i < len(line) {
+		switch {
+		case line[i] == '\\':
+			i += 2
+
+		case line[i] == '`':
+			// Skip code spans
+			run := 0
+			for i+run < len(line) && line[i+run] == '`' {
+				run++
+			}
+			closing := strings.Index(line[i+run:], strings.Repeat("`", run))
+			if closing == -1 {
+				i += run
+			} else {
+				i += run + closing + run
+			}
+
+		case line[i] == '<':
+			match := autolinkRegex.FindStringSubmatch(line[i:])
+			if match == nil {
+				i++
+				continue
+			}
+			target := match[1]
+			if !urlSchemeRegex.MatchString(target) {
+				target = "mailto:" + target
+			}
+			links = append(links, Link{
+				Raw:    match[0],
+				Text:   match[1],
+				Target: target,
+				Type:   "autolink",
+				Line:   lineIndex,
+			})
+			i += len(match[0])
+
+		case strings.HasPrefix(line[i:], "[[") || strings.HasPrefix(line[i:], "![["):
+			start := i
+			isImage := line[i] == '!'
+			if isImage {
+				i++
+			}
+			end := -1
+			if i+2 <= lastWikiEnd {
+				end = strings.Index(line[i+2:], "]]")
+			}
+			if end == -1 {
+				i += 2
+				continue
+			}
+			inner := line[i+2 : i+2+end]
+			i += 2 + end + 2
+
+			target, text, hasAlias := strings.Cut(inner, "|")
+			if !hasAlias {
+				text = target
+			}
+			links = append(links, Link{
+				Raw:     line[start:i],
+				Text:    strings.TrimSpace(text),
+				Target:  strings.TrimSpace(target),
+				Type:    "wikilink",
+				IsImage: isImage,
+				Line:    lineIndex,
+			})
+
+		case line[i] == '[' || strings.HasPrefix(line[i:], "!["):
+			start := i
+			isImage := line[i] == '!'
+			if isImage {
+				i++
+			}
+
+			if brackets == nil {
+				brackets = matchBrackets(line, '[', ']')
+			}
+			textEnd := brackets[i]
+			if textEnd == -1 {
+				i++
+				continue
+			}
+			text := line[i+1 : textEnd]
+			after := textEnd + 1
+
+			link := Link{Text: text, IsImage: isImage, Line: lineIndex}
+
+			if after < len(line) && line[after] == '(' {
+				// Inline link: [text](target "title")
+				if parens == nil {
+					parens = matchBrackets(line, '(', ')')
+				}
+				destEnd := parens[after]
+				if destEnd == -1 {
+					i = textEnd
+					continue
+				}
+				link.Target, link.Title = splitLinkDestination(line[after+1 : destEnd])
+				link.Type = "inline"
+				link.Raw = line[start : destEnd+1]
+				links = append(links, nestedImages(doc, link, lineIndex)...)
+				i = destEnd + 1
+				continue
+			}
+
+			// Reference link: [text][label], [text][] or [text]
+			label := text
+			end := textEnd + 1
+			if after < len(line) && line[after] == '[' {
+				labelEnd := strings.IndexByte(line[after:], ']')
+				if labelEnd != -1 {
+					if labelEnd > 1 {
+						label = line[after+1 : after+labelEnd]
+					}
+					end = after + labelEnd + 1
+				}
+			}
+
+			// Labels of definitions have at most 999 characters, checking
+			// that first keeps long nested brackets from being normalized
+			// over and over
+			var ref LinkReference
+			ok := false
+			if len(label) <= 999 {
+				ref, ok = doc.References[normalizeReferenceLabel(label)]
+			}
+			if !ok {
+				// Not a link, look for links inside of the brackets instead
+				i = start + 1
+				if isImage {
+					i++
+				}
+				continue
+			}
+
+			link.Target = ref.Target
+			link.Title = ref.Title
+			link.Type = "reference"
+			link.Raw = line[start:end]
+			links = append(links, nestedImages(doc, link, lineIndex)...)
+			i = end
+
+		default:
+			i++
+		}
+	}
+
+	return links
+}
+
+// nestedImages returns a link followed by the images in its text (like
+// badges, [![alt](badge.svg)](url)), replacing their markup in the link's text
+// with their alt text. Other links can't be nested in a link.
+func nestedImages(doc *Document, link Link, lineIndex int) []Link {
+	links := []Link{link}
+	if link.IsImage {
+		return links
+	}
+	for _, nested := range parseInlineLinks(doc, link.Text, lineIndex) {
+		if nested.IsImage {
+			links = append(links, nested)
+			links[0].Text = strings.Replace(links[0].Text, nested.Raw, nested.Text, 1)
+		}
+	}
+	return links
+}
+
+// matchBrackets returns the index of the closing bracket for each opening
+// bracket of the line, or -1 if it isn't closed, taking nested brackets and
+// escapes into account
+func matchBrackets(line string, open byte, close byte) []int {
+	matches := make([]int, len(line))
+	for i := range matches {
+		matches[i] = -1
+	}
+	var stack []int
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case open:
+			stack = append(stack, i)
+		case close:
+			if len(stack) > 0 {
+				matches[stack[len(stack)-1]] = i
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	return matches
+}
+
+// splitLinkDestination splits the inside of an inline link's parentheses
+// into the target and the optional title
+func splitLinkDestination(destination string) (string, string) {
+	destination = strings.TrimSpace(destination)
+	if strings.HasPrefix(destination, "<") {
+		if end := strings.IndexByte(destination, '>'); end != -1 {
+			return destination[1:end], trimLinkTitle(strings.TrimSpace(destination[end+1:]))
+		}
+	}
+
+	target, title, _ := strings.Cut(destination, " ")
+	return target, trimLinkTitle(strings.TrimSpace(title))
+}
+
+func trimLinkTitle(title string) string {
+	if len(title) >= 2 {
+		first, last := title[0], title[len(title)-1]
+		if (first == '"' && last == '"') || (first == '\'' && last == '\'') || (first == '(' && last == ')') {
+			return title[1 : len(title)-1]
+		}
+	}
+	return title
+}
+
+// normalizeReferenceLabel makes reference labels case-insensitive and
+// collapses inner whitespace like the CommonMark spec requires
+func normalizeReferenceLabel(label string) string {
+	return strings.ToLower(strings.Join(strings.Fields(label), " "))
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// lintIssue is a problem found in a query
+type lintIssue struct {
+	Source  string // "query" or "path:line"
+	Message string
+}
+
+func (issue lintIssue) String() string {
+	return issue.Source + ": " + issue.Message
+}
+
+// lintQuery checks a query for deprecated syntax, metadata keys that don't
+// exist in the vault, SORT fields in queries that can't sort by them and
+// clauses without any effect. knownKeys are the metadata keys of the vault; if
+// it's nil, keys aren't checked.
+func lintQuery(query string, knownKeys map[string]bool) []string {
+	var messages []string
+
+	tokens := Lex(query)
+	for _, token := range tokens {
+		if token.Type == TOKEN_TABLE_NO_ID {
+			messages = append(messages, "TABLE_NO_ID is deprecated, use TABLE NO ID")
+		}
+	}
+
+	// Non-table queries can only be sorted by their text. That's an error
+	// when parsing, so the fields are reported and dropped here to check the
+	// rest of the query.
+	typeIndex := 0
+	if len(tokens) > 0 && tokens[0].Type == TOKEN_KEYWORD && tokens[0].Value == "EXPLAIN" {
+		typeIndex = 1
+	}
+	if len(tokens) > typeIndex && tokens[typeIndex].Type == TOKEN_KEYWORD && tokens[typeIndex].Value != "MDTABLE" {
+		inSort := false
+		var kept []Token
+		for _, token := range tokens {
+			switch {
+			case token.Type == TOKEN_SORT:
+				inSort = true
+			case token.Type == TOKEN_GROUP || (token.Type == TOKEN_KEYWORD && slices.Contains(clauseKeywords, token.Value)):
+				inSort = false
+			case inSort && token.Type == TOKEN_METADATA:
+				messages = append(messages, fmt.Sprintf("SORT by [%s] only works in TABLE and MDTABLE queries, %s results are sorted by their text", token.Value, tokens[typeIndex].Value))
+				continue
+			}
+			kept = append(kept, token)
+		}
+		tokens = kept
+	}
+
+	ast, err := parseUnbound(tokens)
+	if err != nil {
+		return append(messages, err.Error())
+	}
+
+	isTable := ast.Type == TABLE || ast.Type == TABLE_NO_ID
+	stages := ast.stages()
+
+	if isTable && ast.GroupBy != "" {
+		messages = append(messages, "GROUP BY has no effect on TABLE queries")
+	}
+	for i, stage := range stages {
+		if isTable {
+			for _, sortNode := range stage.Sorts {
+				if !isSortColumn(ast, sortNode) {
+					messages = append(messages, fmt.Sprintf("SORT by [%s] has no effect, TABLE queries can only sort by their columns", sortNode.Metadata))
+				}
+			}
+		}
+		// Sorting by text again replaces the order of the SORT before it
+		if !isTable && ast.Type != MDTABLE && len(stage.Sorts) > 0 && stage.Limit < 0 && stage.Offset == 0 && i < len(stages)-1 && len(stages[i+1].Sorts) > 0 {
+			messages = append(messages, fmt.Sprintf("SORT %s has no effect, it's followed by another SORT without a LIMIT or OFFSET in between", stage.Sorts[0].SortDirection))
+		}
+	}
+
+	for _, clause := range ast.Where.clauses() {
+		seen := make(map[string]bool)
+		for _, condition := range clause.Conditions {
+			formatted := formatCondition(condition)
+			if seen[formatted] {
+				messages = append(messages, fmt.Sprintf("the condition %s is repeated", formatted))
+			}
+			seen[formatted] = true
+
+			if condition.Function == "CHECKED" && ast.Type != TASK {
+				messages = append(messages, fmt.Sprintf("CHECKED only matches tasks, it never matches in %s queries", ast.Type))
+			}
+		}
+	}
+
+	if knownKeys != nil {
+		for _, field := range queryFields(ast) {
+			if !isKnownField(ast.Type, field, knownKeys) {
+				messages = append(messages, fmt.Sprintf("unknown metadata key [%s], no file in the vault has it", field))
+			}
+		}
+	}
+
+	for _, pattern := range ast.Exclude {
+		if !excludesAnything(ast, pattern) {
+			messages = append(messages, fmt.Sprintf("EXCLUDE %q doesn't exclude any file", pattern))
+		}
+	}
+
+	return messages
+}
+
+// queryFields returns the metadata fields a query refers to
+func queryFields(ast *QueryNode) []string {
+	var fields []string
+	for _, column := range ast.Columns {
+		fields = append(fields, column.Name)
+	}
+	for _, clause := range ast.Where.clauses() {
+		for _, condition := range clause.Conditions {
+			if condition.IsMetadata {
+				fields = append(fields, condition.Field)
+			}
+		}
+	}
+	for _, stage := range ast.stages() {
+		for _, sortNode := range stage.Sorts {
+			if sortNode.Metadata != "" && sortNode.Metadata != "File" {
+				fields = append(fields, sortNode.Metadata)
+			}
+		}
+	}
+	if ast.DistinctField != "" {
+		fields = append(fields, ast.DistinctField)
+	}
+	if ast.GroupBy != "" {
+		fields = append(fields, ast.GroupBy)
+	}
+	return fields
+}
+
+// isKnownField checks if a field is in the metadata of the vault or is one of
+// the fields every item of the query type has
+func isKnownField(queryType QueryType, field string, knownKeys map[string]bool) bool {
+	field = strings.ToLower(field)
+	if knownKeys[field] || strings.HasPrefix(field, "item.") {
+		return true
+	}
+	// Columns of markdown tables can have any name
+	if queryType == MDTABLE {
+		return true
+	}
+	for _, itemField := range itemFieldsByType[queryType] {
+		if field == itemField || (strings.HasSuffix(itemField, ".") && strings.HasPrefix(field, itemField)) {
+			return true
+		}
+	}
+	return false
+}
+
+// excludesAnything checks if an exclude pattern removes any file from the
+// sources of a query
+func excludesAnything(ast *QueryNode, pattern string) bool {
+	var others []string
+	for _, other := range ast.Exclude {
+		if other != pattern {
+			others = append(others, other)
+		}
+	}
+
+	with, err := collectMarkdownFiles(ast.From, ast.Exclude)
+	if err != nil {
+		return true
+	}
+	without, err := collectMarkdownFiles(ast.From, others)
+	if err != nil {
+		return true
+	}
+	return len(without) > len(with)
+}
+
+// vaultMetadataKeys returns the metadata keys of the files in the vault: the
+// default sources of the configuration, the vault root or the working
+// directory
+func vaultMetadataKeys() ([]string, error) {
+	sources := config.From
+	if len(sources) == 0 {
+		sources = []string{"."}
+		if vaultRoot != "" {
+			sources = []string{vaultRoot}
+		}
+	}
+
+	files, err := collectMarkdownFiles(sources, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for _, path := range files {
+		_, metadata, err := parseMarkdownContent(path, &QueryNode{Type: LIST})
+		if err != nil {
+			continue
+		}
+		for key := range metadata {
+			seen[key] = true
+		}
+	}
+
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// runLint runs the "lint" subcommand on the query given with -q (or read
+// from stdin), or on the query blocks of markdown files and directories
+func runLint(args []string) error {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	var query string
+	flags.StringVar(&query, "query", "", "query to check")
+	flags.StringVar(&query, "q", "", "query to check (shorthand)")
+	flags.StringVar(&rootFlag, "root", "", "root directory of the vault that paths are resolved against")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: dynomark lint [flags] [-q query | path...]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if err := resolveVaultRoot(rootFlag); err != nil {
+		return err
+	}
+	if err := loadConfig(); err != nil {
+		return err
+	}
+
+	if query == "" && flags.NArg() == 0 {
+		stat, _ := os.Stdin.Stat()
+		if (stat.Mode() & os.ModeCharDevice) != 0 {
+			flags.Usage()
+			return fmt.Errorf("no query or files to check")
+		}
+		input, err := readFromPipe()
+		if err != nil {
+			return err
+		}
+		query = input
+	}
+
+	keys, err := vaultMetadataKeys()
+	if err != nil {
+		return err
+	}
+	knownKeys := make(map[string]bool)
+	for _, key := range keys {
+		knownKeys[key] = true
+	}
+
+	var issues []lintIssue
+	if query != "" {
+		for _, message := range lintQuery(query, knownKeys) {
+			issues = append(issues, lintIssue{Source: "query", Message: message})
+		}
+	} else {
+		issues, err = lintFiles(flags.Args(), knownKeys)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+	if len(issues) == 1 {
+		return fmt.Errorf("found 1 issue")
+	}
+	if len(issues) > 1 {
+		return fmt.Errorf("found %d issues", len(issues))
+	}
+	return nil
+}
+
+// lintFiles checks the query blocks of markdown files and directories
+func lintFiles(paths []string, knownKeys map[string]bool) ([]lintIssue, error) {
+	files, err := collectMarkdownFiles(paths, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var issues []lintIssue
+	for _, path := range files {
+		lines, err := readMarkdownFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, block := range findQueryBlocks(lines) {
+			for _, message := range lintQuery(block.Query, knownKeys) {
+				issues = append(issues, lintIssue{Source: fmt.Sprintf("%s:%d", relativePath(path), block.Line+1), Message: message})
+			}
+			if formatted, err := formatQuery(block.Query); err == nil && formatted != block.Query {
+				issues = append(issues, lintIssue{Source: fmt.Sprintf("%s:%d", relativePath(path), block.Line+1), Message: "query isn't formatted, run dynomark fmt -w"})
+			}
+		}
+	}
+	return issues, nil
+}
@@ -8,11 +8,11 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
-	"unicode"
 	"unicode/utf8"
 )
 
@@ -37,6 +37,7 @@ const (
 	TOKEN_GROUP
 	TOKEN_BY
 	TOKEN_SORT
+	TOKEN_VARIABLE
 )
 
 var TokenTypeNames = map[TokenType]string{
@@ -56,6 +57,7 @@ var TokenTypeNames = map[TokenType]string{
 	TOKEN_GROUP:       "TOKEN_GROUP",
 	TOKEN_BY:          "TOKEN_BY",
 	TOKEN_SORT:        "TOKEN_SORT",
+	TOKEN_VARIABLE:    "TOKEN_VARIABLE",
 }
 
 func (t TokenType) String() string {
@@ -78,24 +80,76 @@ const (
 	ORDEREDLIST   QueryType = "ORDEREDLIST"
 	UNORDEREDLIST QueryType = "UNORDEREDLIST"
 	FENCEDCODE    QueryType = "FENCEDCODE"
+	HEADING       QueryType = "HEADING"
+	SECTION       QueryType = "SECTION"
+	BLOCKQUOTE    QueryType = "BLOCKQUOTE"
+	CALLOUT       QueryType = "CALLOUT"
+	LINK          QueryType = "LINK"
+	IMAGE         QueryType = "IMAGE"
+	MDTABLE       QueryType = "MDTABLE"
 	TABLE         QueryType = "TABLE"
 	TABLE_NO_ID   QueryType = "TABLE_NO_ID"
 )
 
+// ContentItem is a single result extracted from a markdown file. Line is the
+// index of the line where the item starts and Fields holds item level
+// metadata (e.g. the level of a heading) that is merged on top of the file
+// metadata.
+type ContentItem struct {
+	Text    string
+	Line    int // Index of the first line of the item
+	EndLine int // Index of the last line of the item (0 if it's on one line)
+	Fields  Metadata
+}
+
 type ColumnDefinition struct {
 	Name  string
 	Alias string
 }
 
 type QueryNode struct {
-	Type       QueryType
-	From       []string
-	Where      *WhereNode
-	GroupBy    string
-	GroupLimit int
-	Limit      int
-	Columns    []ColumnDefinition
-	Sorts      []SortNode
+	Explain       bool               `json:",omitempty"` // Show the plan instead of running the query (EXPLAIN)
+	Type          QueryType          `json:"Type"`
+	Section       string             `json:",omitempty"` // Heading name for SECTION queries
+	SectionDirect bool               `json:",omitempty"` // Only return the direct body of a section
+	From          []string           `json:",omitempty"`
+	Exclude       []string           `json:",omitempty"` // Paths and patterns excluded from FROM (EXCLUDE or "-" prefix)
+	InSection     string             `json:",omitempty"` // Only return items under this heading (IN SECTION)
+	Where         *WhereNode         `json:",omitempty"`
+	Distinct      bool               `json:",omitempty"` // Drop results with the same text or DistinctField (DISTINCT)
+	DistinctField string             `json:",omitempty"`
+	GroupBy       string             `json:",omitempty"`
+	GroupLimit    int                `json:",omitempty"`
+	Limit         int                `json:"Limit"`
+	Offset        int                `json:",omitempty"` // Results skipped before the LIMIT (OFFSET)
+	Columns       []ColumnDefinition `json:",omitempty"`
+	Sorts         []SortNode         `json:",omitempty"`
+	Stages        []StageNode        `json:",omitempty"` // SORT, LIMIT and OFFSET clauses after the first ones
+}
+
+// StageNode is a SORT, an OFFSET and a LIMIT applied to the results of the
+// stage before it. The Sorts, Limit and Offset of a query are its first stage.
+type StageNode struct {
+	Sorts  []SortNode `json:",omitempty"`
+	Limit  int        `json:"Limit"`
+	Offset int        `json:",omitempty"`
+}
+
+// stages returns the SORT, LIMIT and OFFSET stages of a query in the order
+// they're applied
+func (q *QueryNode) stages() []StageNode {
+	return append([]StageNode{{Sorts: q.Sorts, Limit: q.Limit, Offset: q.Offset}}, q.Stages...)
+}
+
+// window returns the bounds of the results of a stage among n results: its
+// OFFSET is skipped, then its LIMIT is taken
+func (stage StageNode) window(n int) (start, end int) {
+	start = min(stage.Offset, n)
+	end = n
+	if stage.Limit >= 0 && start+stage.Limit < end {
+		end = start + stage.Limit
+	}
+	return start, end
 }
 
 type SortNode struct {
@@ -105,15 +159,24 @@ type SortNode struct {
 
 type WhereNode struct {
 	Conditions []ConditionNode
+	And        []*WhereNode `json:",omitempty"` // Further WHERE clauses, which all have to match
+}
+
+// clauses returns the WHERE clauses of a query
+func (w *WhereNode) clauses() []*WhereNode {
+	if w == nil {
+		return nil
+	}
+	return append([]*WhereNode{w}, w.And...)
 }
 
 type ConditionNode struct {
 	IsNegated  bool
 	IsMetadata bool
-	Field      string // Metadata field
+	Field      string `json:",omitempty"` // Metadata field
 	Function   string
-	Value      string
-	LogicalOp  string // "AND" or "OR"
+	Value      string `json:",omitempty"`
+	LogicalOp  string `json:",omitempty"` // "AND" or "OR"
 }
 
 func Lex(input string) []Token {
@@ -129,15 +192,16 @@ func Lex(input string) []Token {
 		}
 	}
 
-	got_from := false
 	got_where := false
-	got_sort := false
 	insideQuotes := false
 	var quotedString string
 
 	for _, word := range words {
-		// Handle metadata (e.g. [author])
-		if strings.HasPrefix(word, "[") && strings.HasSuffix(word, "]") {
+		// [[#]] is the note given with -context-file, like in dataview
+		if word == "[[#]]" && !insideQuotes {
+			tokens = append(tokens, Token{Type: TOKEN_VARIABLE, Value: "this.file"})
+			// Handle metadata (e.g. [author])
+		} else if strings.HasPrefix(word, "[") && strings.HasSuffix(word, "]") {
 			tokens = append(tokens, Token{Type: TOKEN_METADATA, Value: strings.Trim(word, "[]")})
 			// Handle quoted strings (even if they contain spaces)
 		} else if strings.HasPrefix(word, "\"") && !insideQuotes {
@@ -156,6 +220,12 @@ func Lex(input string) []Token {
 			} else {
 				quotedString += " " + word
 			}
+		} else if isVariable(word) {
+			tokens = append(tokens, Token{Type: TOKEN_VARIABLE, Value: word[1:]})
+		} else if got_where && len(word) > 5 && strings.EqualFold(word[:5], "this.") {
+			// Fields of the context file can be used as values without a $
+			// (e.g. WHERE [project] IS this.project)
+			tokens = append(tokens, Token{Type: TOKEN_VARIABLE, Value: "this." + word[5:]})
 		} else {
 			switch strings.ToUpper(word) {
 			case "TABLE":
@@ -166,11 +236,18 @@ func Lex(input string) []Token {
 				tokens = append(tokens, Token{Type: TOKEN_TABLE_NO_ID, Value: "TABLE_NO_ID"})
 			case "AS":
 				tokens = append(tokens, Token{Type: TOKEN_AS, Value: "AS"})
-			case "LIST", "TASK", "PARAGRAPH", "ORDEREDLIST", "UNORDEREDLIST", "FENCEDCODE", "LIMIT", "CHECKED":
+			case "LIST", "TASK", "PARAGRAPH", "ORDEREDLIST", "UNORDEREDLIST", "FENCEDCODE", "HEADING", "SECTION", "BLOCKQUOTE", "CALLOUT", "LINK", "IMAGE", "MDTABLE", "LIMIT", "OFFSET", "CHECKED":
 				tokens = append(tokens, Token{Type: TOKEN_KEYWORD, Value: strings.ToUpper(word)})
 			case "FROM":
 				tokens = append(tokens, Token{Type: TOKEN_KEYWORD, Value: "FROM"})
-				got_from = true
+			case "EXCLUDE":
+				tokens = append(tokens, Token{Type: TOKEN_KEYWORD, Value: "EXCLUDE"})
+			case "EXPLAIN":
+				tokens = append(tokens, Token{Type: TOKEN_KEYWORD, Value: "EXPLAIN"})
+			case "DISTINCT":
+				tokens = append(tokens, Token{Type: TOKEN_KEYWORD, Value: "DISTINCT"})
+			case "IN":
+				tokens = append(tokens, Token{Type: TOKEN_KEYWORD, Value: "IN"})
 			case "WHERE":
 				tokens = append(tokens, Token{Type: TOKEN_KEYWORD, Value: "WHERE"})
 				got_where = true
@@ -178,7 +255,6 @@ func Lex(input string) []Token {
 				tokens = append(tokens, Token{Type: TOKEN_GROUP, Value: "GROUP"})
 			case "SORT":
 				tokens = append(tokens, Token{Type: TOKEN_SORT, Value: "SORT"})
-				got_sort = true
 			case "BY":
 				tokens = append(tokens, Token{Type: TOKEN_BY, Value: "BY"})
 			case ",":
@@ -187,6 +263,8 @@ func Lex(input string) []Token {
 				tokens = append(tokens, Token{Type: TOKEN_FUNCTION, Value: "CONTAINS"})
 			case "IS":
 				tokens = append(tokens, Token{Type: TOKEN_FUNCTION, Value: "IS"})
+			case "<", "<=", ">", ">=":
+				tokens = append(tokens, Token{Type: TOKEN_FUNCTION, Value: word})
 			case "NOT":
 				tokens = append(tokens, Token{Type: TOKEN_NOT, Value: "NOT"})
 			case "AND", "OR":
@@ -200,8 +278,6 @@ func Lex(input string) []Token {
 					// If previous tokens were 'TABLE' and 'NO', and current word is 'ID', uppercase it
 				} else if len(tokens) > 1 && tokens[len(tokens)-2].Type == TOKEN_TABLE && tokens[len(tokens)-1].Type == TOKEN_IDENTIFIER && strings.ToUpper(word) == "ID" {
 					tokens = append(tokens, Token{Type: TOKEN_IDENTIFIER, Value: "ID"})
-				} else if got_from && !got_where && !got_sort {
-					tokens = append(tokens, Token{Type: TOKEN_STRING, Value: word})
 				} else {
 					tokens = append(tokens, Token{Type: TOKEN_IDENTIFIER, Value: word})
 				}
@@ -213,315 +289,6 @@ func Lex(input string) []Token {
 	return tokens
 }
 
-func Parse(tokens []Token) (*QueryNode, error) {
-	query := &QueryNode{Limit: -1}
-
-	i := 0
-
-	if tokens[i].Type == TOKEN_TABLE {
-		query.Type = TABLE
-		// Check for 'NO ID' after 'TABLE'
-		if i+2 < len(tokens) &&
-			tokens[i+1].Type == TOKEN_IDENTIFIER && tokens[i+1].Value == "NO" &&
-			tokens[i+2].Type == TOKEN_IDENTIFIER && tokens[i+2].Value == "ID" {
-			query.Type = TABLE_NO_ID
-			i += 3
-		} else {
-			i++
-		}
-	} else if tokens[i].Type == TOKEN_TABLE_NO_ID {
-		// DEPRECATED: Handle the old TOKEN_TABLE_NO_ID for backward compatibility
-		query.Type = TABLE_NO_ID
-		i++
-	} else {
-		if tokens[i].Type != TOKEN_KEYWORD {
-			return nil, fmt.Errorf("expected valid query type, got %s", tokens[i].Value)
-		}
-		query.Type = parseQueryType(tokens[i].Value)
-		i++
-	}
-
-	// Parse columns for TABLE queries
-	if query.Type == TABLE || query.Type == TABLE_NO_ID {
-		for i < len(tokens) && tokens[i].Type != TOKEN_KEYWORD {
-			if tokens[i].Type == TOKEN_IDENTIFIER {
-				columnName := tokens[i].Value
-				i++
-				if i < len(tokens) && tokens[i].Type == TOKEN_AS {
-					i++
-					if i >= len(tokens) || tokens[i].Type != TOKEN_STRING {
-						return nil, fmt.Errorf("expected column alias, got %s", tokens[i].Value)
-					}
-					query.Columns = append(query.Columns, ColumnDefinition{
-						Name:  columnName,
-						Alias: tokens[i].Value,
-					})
-					i++
-				} else {
-					query.Columns = append(query.Columns, ColumnDefinition{
-						Name:  columnName,
-						Alias: columnName,
-					})
-				}
-			} else if tokens[i].Type == TOKEN_COMMA {
-				i++
-			} else {
-				return nil, fmt.Errorf("expected column name or comma, got %s", tokens[i].Value)
-			}
-		}
-	}
-
-	// Parse FROM clause
-	if tokens[i].Value != "FROM" {
-		return nil, fmt.Errorf("expected FROM, got %s", tokens[i].Value)
-	} else {
-		i++
-	}
-
-	for i < len(tokens) && tokens[i].Type != TOKEN_KEYWORD {
-		if tokens[i].Type == TOKEN_GROUP || tokens[i].Type == TOKEN_SORT {
-			break
-		} else if tokens[i].Type == TOKEN_STRING {
-			query.From = append(query.From, tokens[i].Value)
-		}
-		i++
-	}
-
-	// Parse WHERE clause
-	if i < len(tokens) && tokens[i].Value == "WHERE" {
-		whereNode, newIndex, err := parseWhereClause(tokens[i+1:])
-		if err != nil {
-			return nil, fmt.Errorf("error parsing WHERE clause: %w", err)
-		}
-		query.Where = whereNode
-		i += newIndex + 1
-	}
-
-	// Parse SORT clause
-	if i < len(tokens) && tokens[i].Value == "SORT" {
-		sortNodes, newIndex, err := parseSortClause(tokens[i+1:], query)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing SORT clause: %w", err)
-		}
-		query.Sorts = sortNodes
-		i += newIndex + 1
-	}
-
-	// Parse GROUP BY clause
-	if i < len(tokens) && tokens[i].Type == TOKEN_GROUP {
-		i++
-		if i < len(tokens) && tokens[i].Type == TOKEN_BY {
-			i++
-			if i < len(tokens) && tokens[i].Type == TOKEN_NUMBER {
-				query.GroupLimit, _ = strconv.Atoi(tokens[i].Value)
-				i++
-				if i < len(tokens) && tokens[i].Type != TOKEN_METADATA {
-					return nil, fmt.Errorf("expected metadata field after GROUP BY %s, got %s", tokens[i-1].Value, tokens[i].Value)
-				}
-			}
-			if i < len(tokens) && tokens[i].Type == TOKEN_METADATA {
-				query.GroupBy = tokens[i].Value
-				i++
-			} else {
-				return nil, fmt.Errorf("expected metadata field after GROUP BY, got %s", tokens[i].Value)
-			}
-		} else {
-			return nil, fmt.Errorf("expected BY after GROUP, got %s", tokens[i].Value)
-		}
-	}
-
-	// Parse LIMIT clause
-	if i < len(tokens) && tokens[i].Value == "LIMIT" {
-		if i+1 >= len(tokens) || tokens[i+1].Type != TOKEN_NUMBER {
-			return nil, fmt.Errorf("invalid LIMIT clause")
-		}
-		limit, err := strconv.Atoi(tokens[i+1].Value)
-		if err != nil {
-			return nil, fmt.Errorf("invalid LIMIT value: %w", err)
-		}
-		query.Limit = limit
-	}
-
-	return query, nil
-}
-
-func parseQueryType(value string) QueryType {
-	switch value {
-	case "LIST":
-		return LIST
-	case "TASK":
-		return TASK
-	case "PARAGRAPH":
-		return PARAGRAPH
-	case "ORDEREDLIST":
-		return ORDEREDLIST
-	case "UNORDEREDLIST":
-		return UNORDEREDLIST
-	case "FENCEDCODE":
-		return FENCEDCODE
-	case "TABLE":
-		return TABLE
-	default:
-		return ""
-	}
-}
-
-func parseSortClause(tokens []Token, queryNode *QueryNode) ([]SortNode, int, error) {
-	i := 0
-	var gotGroup bool
-	var gotLimit bool
-	var sortNodes []SortNode
-	var sortTokens []Token
-
-	// Isolate the sort tokens here
-	for i < len(tokens) && tokens[i].Value != "LIMIT" && tokens[i].Value != "GROUP" && tokens[i].Type != TOKEN_EOF {
-		switch tokens[i].Type {
-		case TOKEN_METADATA:
-			sortTokens = append(sortTokens, tokens[i])
-		case TOKEN_COMMA:
-			sortTokens = append(sortTokens, tokens[i])
-		case TOKEN_STRING, TOKEN_IDENTIFIER:
-			if strings.ToUpper(tokens[i].Value) == "DESC" || strings.ToUpper(tokens[i].Value) == "ASC" {
-				sortTokens = append(sortTokens, tokens[i])
-			}
-		}
-
-		i++
-	}
-
-	// Split sortTokens into separate sortTokens based on commas
-	i = 0
-	newSortTokens := make([][]Token, 0)
-	for i < len(sortTokens) {
-		if sortTokens[i].Type == TOKEN_COMMA {
-			i++
-			continue
-		}
-		var sortToken []Token
-		for i < len(sortTokens) && sortTokens[i].Type != TOKEN_COMMA {
-			sortToken = append(sortToken, sortTokens[i])
-			i++
-		}
-		newSortTokens = append(newSortTokens, sortToken)
-	}
-
-	// Parse each sortToken and create a SortNode
-	for _, sortToken := range newSortTokens {
-		sortNode := SortNode{SortDirection: "ASC"}
-		for _, token := range sortToken {
-			if queryNode.Type == TABLE || queryNode.Type == TABLE_NO_ID {
-				if token.Type == TOKEN_METADATA {
-					sortNode.Metadata = token.Value
-				} else if strings.ToUpper(token.Value) == "DESC" {
-					if sortNode.Metadata != "" {
-						sortNode.SortDirection = "DESC"
-					} else {
-						return sortNodes, 0, fmt.Errorf("expected metadata field before DESC, got DESC")
-					}
-				} else if strings.ToUpper(token.Value) == "ASC" {
-					if sortNode.Metadata != "" {
-						sortNode.SortDirection = "ASC"
-					} else {
-						return sortNodes, 0, fmt.Errorf("expected metadata field before ASC, got ASC")
-					}
-				} else if token.Value == "GROUP" {
-					gotGroup = true
-					break
-				} else if token.Value == "LIMIT" {
-					gotLimit = true
-					break
-				} else {
-					return sortNodes, 0, fmt.Errorf("expected metadata field or DESC/ASC, got %s", token.Value)
-				}
-			} else {
-				if token.Type == TOKEN_METADATA {
-					return sortNodes, 0, fmt.Errorf("metadata field not allowed in non-TABLE queries")
-				} else if strings.ToUpper(token.Value) == "DESC" {
-					sortNode.SortDirection = "DESC"
-				} else if strings.ToUpper(token.Value) == "ASC" {
-					sortNode.SortDirection = "ASC"
-				} else if token.Value == "GROUP" {
-					gotGroup = true
-					break
-				} else if token.Value == "LIMIT" {
-					gotLimit = true
-					break
-				} else {
-					return sortNodes, 0, fmt.Errorf("expected DESC/ASC, got %s", token.Value)
-				}
-			}
-		}
-
-		if gotGroup || gotLimit {
-			break
-		}
-
-		sortNodes = append(sortNodes, sortNode)
-	}
-
-	// If querytype not table or table_no_id, take the last sort node and return just that
-	if queryNode.Type != TABLE && queryNode.Type != TABLE_NO_ID {
-		if len(sortNodes) == 0 {
-			sortNode := SortNode{SortDirection: "ASC"}
-			sortNodes = append(sortNodes, sortNode)
-		} else {
-			sortNodes = sortNodes[len(sortNodes)-1:]
-		}
-
-	}
-
-	return sortNodes, i, nil
-}
-
-func parseWhereClause(tokens []Token) (*WhereNode, int, error) {
-	whereNode := &WhereNode{}
-	i := 0
-	var currentCondition ConditionNode
-	var logicalOp string
-	var gotGroup bool
-	var gotSort bool
-
-	for i < len(tokens) && tokens[i].Value != "LIMIT" {
-		switch tokens[i].Type {
-		case TOKEN_GROUP:
-			gotGroup = true
-			break
-		case TOKEN_SORT:
-			gotSort = true
-			break
-		case TOKEN_METADATA:
-			currentCondition.IsMetadata = true
-			currentCondition.Field = tokens[i].Value
-		case TOKEN_NOT:
-			currentCondition.IsNegated = true
-		case TOKEN_FUNCTION:
-			currentCondition.Function = tokens[i].Value
-		case TOKEN_STRING:
-			currentCondition.Value = tokens[i].Value
-			currentCondition.LogicalOp = logicalOp
-			whereNode.Conditions = append(whereNode.Conditions, currentCondition)
-			currentCondition = ConditionNode{}
-			logicalOp = ""
-		case TOKEN_LOGICAL_OP:
-			logicalOp = tokens[i].Value
-		case TOKEN_KEYWORD:
-			if tokens[i].Value == "CHECKED" {
-				currentCondition.Function = "CHECKED"
-				currentCondition.LogicalOp = logicalOp
-				whereNode.Conditions = append(whereNode.Conditions, currentCondition)
-				currentCondition = ConditionNode{}
-				logicalOp = ""
-			}
-		}
-		if gotGroup || gotSort {
-			break
-		}
-		i++
-	}
-
-	return whereNode, i, nil
-}
-
 func InterpretTableQuery(ast *QueryNode) (string, error) {
 	var result strings.Builder
 	var headers []string
@@ -533,52 +300,30 @@ func InterpretTableQuery(ast *QueryNode) (string, error) {
 		headers = append(headers, col.Alias)
 	}
 
-	// Initialize maxWidths with the length of headers
-	maxWidths := make([]int, len(headers))
-	for i, header := range headers {
-		maxWidths[i] = utf8.RuneCountInString(header)
-	}
-
-	// Collect all rows and calculate max width for each column
+	// Collect all rows
 	var rows [][]string
 	var rowsMetadata []map[string]interface{} // Store metadata for sorting
-	var paths []string
-
-	for _, path := range ast.From {
-		info, err := os.Stat(path)
-		if err != nil {
-			return "", err
-		}
+	distinctRows := make(map[string]bool)
 
-		if info.IsDir() {
-			err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
-				if !info.IsDir() && strings.HasSuffix(info.Name(), ".md") {
-					paths = append(paths, p)
-				}
-				return nil
-			})
-			if err != nil {
-				return "", err
-			}
-		} else {
-			paths = append(paths, path)
-		}
+	paths, err := collectMarkdownFiles(ast.From, ast.Exclude)
+	if err != nil {
+		return "", err
 	}
 
 	for _, path := range paths {
-		_, metadata, err := parseMarkdownContent(path, ast.Type)
+		_, metadata, err := parseMarkdownContent(path, ast)
 		if err != nil {
 			return "", err
 		}
 
+		// File doesn't contain the section from the IN SECTION clause
+		if metadata == nil {
+			continue
+		}
+
 		// Apply WHERE conditions to filter rows
-		if ast.Where != nil {
-			if !applyConditions("", metadata, ast.Where.Conditions) {
-				continue
-			}
+		if !applyWhere("", metadata, ast.Where) {
+			continue
 		}
 
 		var row []string
@@ -595,11 +340,13 @@ func InterpretTableQuery(ast *QueryNode) (string, error) {
 			}
 		}
 
-		// Update maxWidths based on the current row
-		for i, cell := range row {
-			if utf8.RuneCountInString(cell) > maxWidths[i] {
-				maxWidths[i] = utf8.RuneCountInString(cell)
+		// DISTINCT compares all the cells of a row, or the value of its field
+		if ast.Distinct {
+			key := distinctKey(strings.Join(row, "\x00"), metadata, ast.DistinctField)
+			if distinctRows[key] {
+				continue
 			}
+			distinctRows[key] = true
 		}
 
 		rows = append(rows, row)
@@ -613,62 +360,64 @@ func InterpretTableQuery(ast *QueryNode) (string, error) {
 		}
 	}
 
-	// Sort the rows based on multiple fields
-	if len(ast.Sorts) > 0 {
-		sort.Slice(rows, func(i, j int) bool {
-			// Compare rows based on each sort criterion
-			for _, sortNode := range ast.Sorts {
-				// Find the column index for the metadata field
-				colIndex := -1
-				if sortNode.Metadata == "File" && ast.Type == TABLE {
-					colIndex = 0
-				} else {
-					for idx, col := range ast.Columns {
-						if col.Name == sortNode.Metadata {
-							colIndex = idx
-							if ast.Type == TABLE {
-								colIndex++ // Adjust for File column
+	// Sort the rows based on multiple fields and keep the rows in the OFFSET
+	// and LIMIT, one stage after the other
+	for _, stage := range ast.stages() {
+		if len(stage.Sorts) > 0 {
+			sort.SliceStable(rows, func(i, j int) bool {
+				// Compare rows based on each sort criterion
+				for _, sortNode := range stage.Sorts {
+					// Find the column index for the metadata field
+					colIndex := -1
+					if sortNode.Metadata == "File" && ast.Type == TABLE {
+						colIndex = 0
+					} else {
+						for idx, col := range ast.Columns {
+							if col.Name == sortNode.Metadata {
+								colIndex = idx
+								if ast.Type == TABLE {
+									colIndex++ // Adjust for File column
+								}
+								break
 							}
-							break
 						}
 					}
-				}
-
-				if colIndex == -1 {
-					continue
-				}
 
-				// Get values to compare
-				val1 := rows[i][colIndex]
-				val2 := rows[j][colIndex]
-
-				// Try to compare as numbers first
-				num1, err1 := strconv.ParseFloat(val1, 64)
-				num2, err2 := strconv.ParseFloat(val2, 64)
-
-				var compareResult int
-				if err1 == nil && err2 == nil {
-					// Numeric comparison
-					if num1 < num2 {
-						compareResult = -1
-					} else if num1 > num2 {
-						compareResult = 1
+					if colIndex == -1 {
+						continue
 					}
-				} else {
-					// String comparison
-					compareResult = strings.Compare(val1, val2)
-				}
 
-				// If values are different, return the comparison result
-				if compareResult != 0 {
-					if sortNode.SortDirection == "DESC" {
-						return compareResult > 0
+					compareResult := compareStrings(rows[i][colIndex], rows[j][colIndex])
+
+					// If values are different, return the comparison result
+					if compareResult != 0 {
+						if sortNode.SortDirection == "DESC" {
+							return compareResult > 0
+						}
+						return compareResult < 0
 					}
-					return compareResult < 0
 				}
-			}
-			return false // If all values are equal
-		})
+				return false // If all values are equal
+			})
+		}
+		start, end := stage.window(len(rows))
+		rows = rows[start:end]
+	}
+
+	if pageFlag > 0 {
+		return renderPage(rows, headers)
+	}
+
+	// Initialize maxWidths with the length of headers, then widen them to
+	// fit the cells of every row
+	maxWidths := make([]int, len(headers))
+	for i, header := range headers {
+		maxWidths[i] = utf8.RuneCountInString(header)
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			maxWidths[i] = max(maxWidths[i], utf8.RuneCountInString(cell))
+		}
 	}
 
 	// Write table headers
@@ -699,43 +448,69 @@ func tablePadString(str string, length int) string {
 }
 
 func Interpret(ast *QueryNode) (string, error) {
+	if err := checkPageFlags(); err != nil {
+		return "", err
+	}
+
 	if ast.Type == TABLE || ast.Type == TABLE_NO_ID {
 		return InterpretTableQuery(ast)
 	}
 
-	content, metadataList, err := parseMarkdownFiles(ast.From, ast.Type)
+	content, metadataList, err := parseMarkdownFiles(ast.From, ast)
 	if err != nil {
 		return "", err
 	}
 
-	// Sort content ASC or DESC
-	// If it's not a table, ast.Sorts will only have one element
-	// Here you can't sort by metadata, so just sort alphabetically
-	// Use NaturalSort for sorting because it's nicer :)
-	if len(ast.Sorts) > 0 {
-		if ast.Sorts[0].SortDirection == "DESC" {
-			sort.Slice(content, func(i, j int) bool {
-				return NaturalSort(content[i], content[j])
-			})
-		} else {
-			sort.Slice(content, func(i, j int) bool {
-				sorted := NaturalSort(content[i], content[j])
-				return !sorted
-			})
-		}
+	content, metadataList = filterContent(content, metadataList, ast.Where)
+	if ast.Distinct {
+		content, metadataList = distinctContent(content, metadataList, ast.DistinctField)
+	}
+
+	content, metadataList, groupStage := applyStages(content, metadataList, ast)
+
+	if outlineFlag && ast.Type == HEADING {
+		content = renderOutline(content, metadataList)
 	}
 
-	if ast.Where != nil {
-		content, metadataList = filterContent(content, metadataList, ast.Where.Conditions)
+	if fencesFlag && ast.Type == FENCEDCODE {
+		content = renderFencedCode(content, metadataList)
+	}
+
+	// Pages of markdown table rows have the columns of all their tables
+	var columns []string
+	if pageFlag > 0 && ast.Type == MDTABLE {
+		columns, _ = tableColumns(metadataList)
 	}
 
 	if ast.GroupBy != "" {
-		// This handles LIMIT too, that's why I can just return it
-		return groupContent(content, metadataList, ast)
+		keys, groups := groupResults(metadataList, ast, groupStage)
+		if pageFlag > 0 {
+			pageGroups := make([]pageGroup, len(keys))
+			for i, key := range keys {
+				pageGroups[i] = pageGroup{Group: key, Results: []any{}}
+				for _, index := range groups[key] {
+					pageGroups[i].Results = append(pageGroups[i].Results, pageItem(ast.Type, content[index], metadataList[index], columns))
+				}
+			}
+			return renderPage(pageGroups, columns)
+		}
+		// This handles OFFSET and LIMIT too, that's why I can just return it
+		return groupContent(content, metadataList, ast, keys, groups)
+	}
+
+	if pageFlag > 0 {
+		items := make([]any, len(content))
+		for i := range content {
+			items[i] = pageItem(ast.Type, content[i], metadataList[i], columns)
+		}
+		return renderPage(items, columns)
 	}
 
-	if ast.Limit >= 0 && ast.Limit < len(content) {
-		content = content[:ast.Limit]
+	if ast.Type == MDTABLE {
+		if printMetadataFlag {
+			printMetadata(metadataList)
+		}
+		return strings.TrimSuffix(renderMarkdownTable(metadataList), "\n"), nil
 	}
 
 	// HACK: This is here because metadata isn't returned far enough in the code.
@@ -745,28 +520,66 @@ func Interpret(ast *QueryNode) (string, error) {
 		printMetadata(metadataList)
 	}
 
+	// Paragraphs keep the blank line between them, like in the document.
+	// Sections and blockquotes are kept apart the same way, consecutive
+	// blockquotes would otherwise read as a single one.
+	if ast.Type == PARAGRAPH || ast.Type == SECTION || ast.Type == BLOCKQUOTE || ast.Type == CALLOUT {
+		return strings.Join(content, "\n\n"), nil
+	}
+
 	return strings.Join(content, "\n"), nil
 }
 
-func groupContent(content []string, metadataList []Metadata, ast *QueryNode) (string, error) {
-	groups := make(map[string][]string)
+// applyStages applies the SORT, OFFSET and LIMIT stages of a query in
+// order. With GROUP BY, the OFFSET and LIMIT of the last stage apply to every
+// group instead, so that stage is returned to be applied by groupResults.
+func applyStages(content []string, metadataList []Metadata, ast *QueryNode) ([]string, []Metadata, StageNode) {
+	stages := ast.stages()
+	groupStage := StageNode{Limit: -1}
+	for i, stage := range stages {
+		// Rows of markdown tables are sorted by their columns, the other
+		// results can't be sorted by metadata and are sorted by their text
+		if ast.Type == MDTABLE {
+			sortByMetadata(content, metadataList, stage.Sorts)
+		} else if len(stage.Sorts) > 0 {
+			sortByText(content, metadataList, stage.Sorts[0].SortDirection)
+		}
+
+		if i == len(stages)-1 && ast.GroupBy != "" {
+			groupStage = stage
+		} else {
+			start, end := stage.window(len(content))
+			content = content[start:end]
+			metadataList = metadataList[start:end]
+		}
+	}
+	return content, metadataList, groupStage
+}
 
-	for i, item := range content {
+// groupResults groups the results by the GROUP BY field of the query. It
+// returns the group names in order and the indexes of the results of every
+// group, with the OFFSET and LIMIT of the stage applied to each of them.
+func groupResults(metadataList []Metadata, ast *QueryNode, stage StageNode) ([]string, map[string][]int) {
+	groups := make(map[string][]int)
+
+	for i := range metadataList {
 		groupValue, ok := metadataList[i][ast.GroupBy]
 		if !ok {
 			groupValue = "Unknown"
 		}
 		groupKey := fmt.Sprintf("%v", groupValue)
-		if ast.Limit > 0 && len(groups[groupKey]) >= ast.Limit {
-			continue
-		}
-		groups[groupKey] = append(groups[groupKey], item)
+		groups[groupKey] = append(groups[groupKey], i)
 	}
 
-	var result strings.Builder
+	// Groups left without results by the OFFSET or LIMIT aren't shown
 	keys := make([]string, 0, len(groups))
-	for k := range groups {
+	for k, indexes := range groups {
+		start, end := stage.window(len(indexes))
+		if start == end {
+			continue
+		}
 		keys = append(keys, k)
+		groups[k] = indexes[start:end]
 	}
 
 	sort.Slice(keys, func(i, j int) bool {
@@ -777,12 +590,39 @@ func groupContent(content []string, metadataList []Metadata, ast *QueryNode) (st
 		keys = keys[:ast.GroupLimit]
 	}
 
+	return keys, groups
+}
+
+func groupContent(content []string, metadataList []Metadata, ast *QueryNode, keys []string, groups map[string][]int) (string, error) {
+	var result strings.Builder
 	for _, key := range keys {
 		result.WriteString(fmt.Sprintf("- %s\n", key))
-		for _, item := range groups[key] {
+
+		// Rows of markdown tables are merged into one table per group
+		if ast.Type == MDTABLE {
+			var groupMetadata []Metadata
+			for _, index := range groups[key] {
+				groupMetadata = append(groupMetadata, metadataList[index])
+			}
+			for _, line := range strings.Split(strings.TrimSuffix(renderMarkdownTable(groupMetadata), "\n"), "\n") {
+				result.WriteString(fmt.Sprintf("    %s\n", line))
+			}
+			result.WriteString("\n")
+			continue
+		}
+
+		for _, index := range groups[key] {
+			item := content[index]
 			switch ast.Type {
-			case TASK, UNORDEREDLIST, ORDEREDLIST, PARAGRAPH, FENCEDCODE:
-				result.WriteString(fmt.Sprintf("    %s\n", item))
+			case TASK, UNORDEREDLIST, ORDEREDLIST, PARAGRAPH, FENCEDCODE, HEADING, SECTION, BLOCKQUOTE, CALLOUT, LINK, IMAGE:
+				// Indent every line so multi-line items stay under their group
+				for _, line := range strings.Split(item, "\n") {
+					if line == "" {
+						result.WriteString("\n")
+					} else {
+						result.WriteString(fmt.Sprintf("    %s\n", line))
+					}
+				}
 			}
 		}
 		result.WriteString("\n")
@@ -791,91 +631,123 @@ func groupContent(content []string, metadataList []Metadata, ast *QueryNode) (st
 	return result.String(), nil
 }
 
-func parseMarkdownContent(path string, queryType QueryType) ([]string, Metadata, error) {
-	file, err := os.Open(path)
+// parseMarkdownContent returns the items of the given query type found in the
+// file together with the file metadata. For LIST and TABLE queries with an
+// IN SECTION clause, the metadata is nil if the file doesn't have the section.
+func parseMarkdownContent(path string, ast *QueryNode) ([]ContentItem, Metadata, error) {
+	lines, err := readMarkdownFile(path)
 	if err != nil {
 		return nil, nil, err
 	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	var lines []string
-	metadata := make(Metadata)
-	inFrontMatter := false
-	frontMatterLines := []string{}
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		lines = append(lines, line)
-
-		trimmedLine := strings.TrimSpace(line)
-		if trimmedLine == "---" {
-			inFrontMatter = !inFrontMatter
-			if !inFrontMatter {
-				// Process YAML front matter
-				for _, fmLine := range frontMatterLines {
-					if strings.Contains(fmLine, ":") {
-						parts := strings.SplitN(fmLine, ":", 2)
-						key := strings.ToLower(strings.TrimSpace(parts[0]))
-						value := strings.TrimSpace(parts[1])
-						value = strings.Trim(value, `"`)
-						if i, err := strconv.Atoi(value); err == nil {
-							metadata[key] = i
-						} else if b, err := strconv.ParseBool(value); err == nil {
-							metadata[key] = b
-						} else {
-							metadata[key] = value
-						}
-					}
-				}
-			}
-			continue
-		}
 
-		if inFrontMatter {
-			frontMatterLines = append(frontMatterLines, trimmedLine)
-		} else {
-			parseMetadataLine(trimmedLine, metadata)
-		}
+	// Frontmatter is only recognized at the top of the file, any other "---"
+	// is a thematic break or a setext heading underline
+	metadata, frontmatterLineCount := parseFrontmatter(lines)
+	if metadata == nil {
+		metadata = make(Metadata)
 	}
+	lines = lines[frontmatterLineCount:]
 
-	if err := scanner.Err(); err != nil {
-		return nil, nil, err
+	// JSX and import/export statements of MDX files aren't markdown
+	if strings.ToLower(filepath.Ext(path)) == ".mdx" {
+		lines = stripMDXSyntax(lines)
+	}
+
+	for _, line := range lines {
+		parseMetadataLine(strings.TrimSpace(line), metadata)
 	}
 
 	// Add file-related metadata
 	addFileMetadata(path, &metadata)
 
+	var doc *Document
+	var headings []Heading
+	if ast.InSection != "" || (ast.Type != TABLE && ast.Type != TABLE_NO_ID && ast.Type != LIST) {
+		doc = cachedDocument(path, lines)
+		headings = parseHeadings(doc)
+	}
+
+	if ast.InSection != "" && (ast.Type == TABLE || ast.Type == TABLE_NO_ID || ast.Type == LIST) {
+		if !hasSection(headings, ast.InSection) {
+			return nil, nil, nil
+		}
+	}
+
 	// For TABLE and TABLE_NO_ID, no need to parse the content
 	// Just return an empty slice for the content and the metadata
-	if queryType == TABLE || queryType == TABLE_NO_ID {
-		return []string{}, metadata, nil
+	if ast.Type == TABLE || ast.Type == TABLE_NO_ID {
+		return []ContentItem{}, metadata, nil
 	}
 
-	// Strip YAML frontmatter from lines
-	lines = stripYAMLFrontmatter(lines)
-
-	var parsedContent []string
-	switch queryType {
+	var parsedContent []ContentItem
+	switch ast.Type {
 	case LIST:
 		parsedContent = nil
 	case TASK:
-		parsedContent = parseTasks(lines)
+		parsedContent = parseTasks(doc)
 	case PARAGRAPH:
-		parsedContent = parseParagraphs(lines)
+		parsedContent = parseParagraphs(doc)
 	case ORDEREDLIST:
-		parsedContent = parseOrderedLists(lines)
+		parsedContent = parseOrderedLists(doc)
 	case UNORDEREDLIST:
-		parsedContent = parseUnorderedLists(lines)
+		parsedContent = parseUnorderedLists(doc)
 	case FENCEDCODE:
-		parsedContent = parseFencedCode(lines)
+		parsedContent = parseFencedCode(doc)
+	case HEADING:
+		for _, heading := range headings {
+			parsedContent = append(parsedContent, ContentItem{
+				Text:    strings.Repeat("#", heading.Level) + " " + heading.Text,
+				Line:    heading.Line,
+				EndLine: heading.EndLine,
+				Fields:  Metadata{"level": heading.Level},
+			})
+		}
+	case SECTION:
+		parsedContent = parseSections(doc, headings, ast.Section, ast.SectionDirect)
+	case BLOCKQUOTE:
+		parsedContent = parseBlockquotes(doc)
+	case CALLOUT:
+		parsedContent = parseCallouts(doc)
+	case LINK:
+		parsedContent = parseLinkItems(doc)
+	case IMAGE:
+		parsedContent = parseImageItems(doc)
+	case MDTABLE:
+		parsedContent = parseMarkdownTables(doc)
 	default:
-		return nil, nil, fmt.Errorf("unsupported query type: %s", queryType)
+		return nil, nil, fmt.Errorf("unsupported query type: %s", ast.Type)
+	}
+
+	parsedContent = applySections(parsedContent, headings, ast.InSection)
+
+	// Report line numbers relative to the whole file (starting at 1)
+	for i := range parsedContent {
+		if parsedContent[i].Fields == nil {
+			parsedContent[i].Fields = make(Metadata)
+		}
+		parsedContent[i].Fields["item.line"] = parsedContent[i].Line + frontmatterLineCount + 1
+		parsedContent[i].Fields["item.end-line"] = max(parsedContent[i].EndLine, parsedContent[i].Line) + frontmatterLineCount + 1
 	}
 
 	return parsedContent, metadata, nil
 }
 
+// itemMetadata merges the item level fields on top of the file metadata
+func itemMetadata(metadata Metadata, item ContentItem) Metadata {
+	if len(item.Fields) == 0 {
+		return metadata
+	}
+
+	merged := make(Metadata, len(metadata)+len(item.Fields))
+	for key, value := range metadata {
+		merged[key] = value
+	}
+	for key, value := range item.Fields {
+		merged[key] = value
+	}
+	return merged
+}
+
 func parseMetadataLine(line string, metadata Metadata) {
 	// Check for metadata in the form of key:: value
 	if !strings.Contains(line, "::") {
@@ -935,276 +807,115 @@ func addFileMetadata(path string, metadata *Metadata) {
 	fileInfo, err := os.Stat(path)
 	if err == nil {
 		(*metadata)["file.folder"] = filepath.Base(filepath.Dir(path))
-		(*metadata)["file.path"] = path
+		(*metadata)["file.path"] = relativePath(path)
 		(*metadata)["file.name"] = filepath.Base(path)
-		(*metadata)["file.shortname"] = filepath.Base(path)[:len(filepath.Base(path))-3]
-		(*metadata)["file.link"] = fmt.Sprintf("[%s](%s)", filepath.Base(path), path)
+		(*metadata)["file.shortname"] = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		(*metadata)["file.ext"] = strings.TrimPrefix(filepath.Ext(path), ".")
+		(*metadata)["file.link"] = fmt.Sprintf("[%s](%s)", filepath.Base(path), relativePath(path))
 		(*metadata)["file.size"] = fileInfo.Size()
 		(*metadata)["file.ctime"] = fileInfo.ModTime().Format(time.RFC3339)
-		(*metadata)["file.cday"] = fileInfo.ModTime().Format("2006-01-02")
+		(*metadata)["file.cday"] = fileInfo.ModTime().Format(config.DateFormat)
 		(*metadata)["file.mtime"] = fileInfo.ModTime().Format(time.RFC3339)
-		(*metadata)["file.mday"] = fileInfo.ModTime().Format("2006-01-02")
+		(*metadata)["file.mday"] = fileInfo.ModTime().Format(config.DateFormat)
 	}
 }
 
-func stripYAMLFrontmatter(lines []string) []string {
-	if len(lines) > 0 && lines[0] == "---" {
-		endIndex := -1
-		for i := 1; i < len(lines); i++ {
-			if lines[i] == "---" {
-				endIndex = i
-				break
-			}
-		}
-		if endIndex != -1 {
-			return lines[endIndex+1:]
+func parseTasks(doc *Document) []ContentItem {
+	var tasks []ContentItem
+	walkBlocks(doc.Root, func(block *Block) bool {
+		if block.Type == BLOCK_LIST_ITEM && isTaskListItem(block) {
+			tasks = append(tasks, ContentItem{Text: doc.Lines[block.StartLine], Line: block.StartLine})
 		}
-	}
-	return lines
+		return true
+	})
+	return tasks
 }
 
-func parseTasks(lines []string) []string {
-	var tasks []string
-	for _, line := range lines {
-		trimmedLine := strings.TrimLeft(line, " \t")
-		if isTaskListItem(trimmedLine) {
-			tasks = append(tasks, line)
+// parseParagraphs returns every top level paragraph as a single item
+func parseParagraphs(doc *Document) []ContentItem {
+	var paragraphs []ContentItem
+	for _, block := range doc.Root.Children {
+		if block.Type == BLOCK_PARAGRAPH {
+			paragraphs = append(paragraphs, ContentItem{
+				Text:    strings.Join(doc.Lines[block.StartLine:block.EndLine+1], "\n"),
+				Line:    block.StartLine,
+				EndLine: block.EndLine,
+			})
 		}
 	}
-	return tasks
+	return paragraphs
 }
 
-func parseParagraphs(lines []string) []string {
-	var paragraphs []string
-	var inCodeBlock bool
-	var inList bool
-	var emptyLineCount int
-
-	for _, line := range lines {
-		// Skip fenced blocks and their content
-		if strings.HasPrefix(line, "```") {
-			inCodeBlock = !inCodeBlock
-			continue
-		}
-
-		if inCodeBlock {
-			continue
-		}
-
-		// Skip headings
-		if strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		// Skip unordered list items and tasks
-		if strings.HasPrefix(line, "- ") || strings.HasPrefix(line, "* ") {
-			inList = true
-			continue
-		}
-
-		// Skip ordered list items
-		if isOrderedListItem(line) {
-			inList = true
-			continue
-		}
+func parseUnorderedLists(doc *Document) []ContentItem {
+	return parseListItems(doc, false)
+}
 
-		// If we're in a list and the line is empty, we're done with the list
-		if inList && strings.TrimSpace(line) == "" {
-			inList = false
-		}
+func parseOrderedLists(doc *Document) []ContentItem {
+	return parseListItems(doc, true)
+}
 
-		// Skip indented lines if we're in a list
-		if inList && len(line)-len(strings.TrimLeft(line, " ")) > 0 {
-			continue
-		}
+// parseListItems returns the items of all bullet or ordered lists, except
+// for tasks. Nested lists are returned as separate items, so an item only
+// spans the lines up to its first nested list.
+func parseListItems(doc *Document, ordered bool) []ContentItem {
+	var items []ContentItem
 
-		// Handle multiple empty lines
-		if strings.TrimSpace(line) == "" {
-			emptyLineCount++
-			// Allow only the first empty line, skip the rest
-			if emptyLineCount > 1 {
-				continue
-			}
-		} else {
-			emptyLineCount = 0 // Reset when a non-empty line is found
+	walkBlocks(doc.Root, func(block *Block) bool {
+		if block.Type != BLOCK_LIST_ITEM || block.List.Ordered != ordered || isTaskListItem(block) {
+			return true
 		}
 
-		paragraphs = append(paragraphs, line)
-	}
-
-	// Remove the first element if it's an empty line
-	if len(paragraphs) > 0 && strings.TrimSpace(paragraphs[0]) == "" {
-		paragraphs = paragraphs[1:]
-	}
-
-	// Remove the last element if it's an empty line
-	if len(paragraphs) > 0 && strings.TrimSpace(paragraphs[len(paragraphs)-1]) == "" {
-		paragraphs = paragraphs[:len(paragraphs)-1]
-	}
-
-	return paragraphs
-}
-
-func parseUnorderedLists(lines []string) []string {
-	var items []string
-	var currentItem []string
-	inList := false
-	indentLevel := 0
-	trailingEmptyLines := 0
-
-	for i, line := range lines {
-		trimmedLine := strings.TrimSpace(line)
-		if isUnorderedListItem(trimmedLine) {
-			if len(currentItem) > 0 {
-				items = append(items, strings.Join(currentItem[:len(currentItem)-trailingEmptyLines], "\n"))
-				currentItem = nil
-				trailingEmptyLines = 0
-			}
-			currentItem = append(currentItem, line)
-			inList = true
-			indentLevel = len(line) - len(trimmedLine)
-		} else if inList && (isUnorderedListItem(line) || len(line)-len(strings.TrimLeft(line, " ")) > indentLevel) {
-			currentItem = append(currentItem[:len(currentItem)-trailingEmptyLines], line)
-			trailingEmptyLines = 0
-		} else if inList && trimmedLine == "" {
-			currentItem = append(currentItem, line)
-			trailingEmptyLines++
-		} else {
-			if len(currentItem) > 0 {
-				items = append(items, strings.Join(currentItem[:len(currentItem)-trailingEmptyLines], "\n"))
-				currentItem = nil
-				trailingEmptyLines = 0
+		end := block.EndLine
+		for _, child := range block.Children {
+			if child.Type == BLOCK_LIST {
+				end = child.StartLine - 1
+				break
 			}
-			inList = false
-			indentLevel = 0
 		}
 
-		// Handle the case when we reach the end of the file
-		if i == len(lines)-1 && len(currentItem) > 0 {
-			items = append(items, strings.Join(currentItem[:len(currentItem)-trailingEmptyLines], "\n"))
+		lines := doc.Lines[block.StartLine : max(end, block.StartLine)+1]
+		for len(lines) > 1 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+			lines = lines[:len(lines)-1]
 		}
-	}
-
-	return items
-}
 
-func parseOrderedLists(lines []string) []string {
-	var items []string
-	var currentItem []string
-	inList := false
-
-	for _, line := range lines {
-		trimmedLine := strings.TrimSpace(line)
-		if isOrderedListItem(trimmedLine) {
-			if inList && len(currentItem) > 0 {
-				items = append(items, strings.Join(currentItem, "\n"))
-				currentItem = nil
-			}
-			currentItem = append(currentItem, line)
-			inList = true
-		} else if inList && trimmedLine == "" {
-			if len(currentItem) > 0 {
-				items = append(items, strings.Join(currentItem, "\n"))
-				currentItem = nil
-			}
-			inList = false
-		} else if inList {
-			currentItem = append(currentItem, line)
-		} else {
-			inList = false
-		}
-	}
-
-	if len(currentItem) > 0 {
-		items = append(items, strings.Join(currentItem, "\n"))
-	}
+		items = append(items, ContentItem{
+			Text:    strings.Join(lines, "\n"),
+			Line:    block.StartLine,
+			EndLine: block.StartLine + len(lines) - 1,
+		})
+		return true
+	})
 
 	return items
 }
 
-func parseFencedCode(lines []string) []string {
-	var fencedCode []string
-	var currentCode []string
-	inCodeBlock := false
-
-	for _, line := range lines {
-		if strings.HasPrefix(line, "```") {
-			if inCodeBlock {
-				fencedCode = append(fencedCode, strings.Join(currentCode, "\n"))
-				currentCode = nil
-				inCodeBlock = false
-			} else {
-				inCodeBlock = true
-			}
-		} else if inCodeBlock {
-			currentCode = append(currentCode, line)
-		}
-	}
-
-	return fencedCode
-}
-
-func parseMarkdownFiles(paths []string, queryType QueryType) ([]string, []Metadata, error) {
+func parseMarkdownFiles(paths []string, ast *QueryNode) ([]string, []Metadata, error) {
 	var results []string
 	var metadataList []Metadata
 
-	for _, path := range paths {
-		if strings.HasPrefix(path, "~") {
-			path = filepath.Join(os.Getenv("HOME"), path[1:])
-		}
-
-		path = os.ExpandEnv(path)
-		fileInfo, err := os.Stat(path)
-		if err != nil {
-			return nil, nil, err
-		}
+	files, err := collectMarkdownFiles(paths, ast.Exclude)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		if fileInfo.IsDir() {
-			err := filepath.Walk(path, func(filePath string, info os.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
-				if !info.IsDir() && filepath.Ext(filePath) == ".md" {
-					if queryType == LIST {
-						results = append(results, "- "+filepath.Base(filePath))
-						_, metadata, err := parseMarkdownContent(filePath, queryType)
-						if err != nil {
-							return err
-						}
-						metadataList = append(metadataList, metadata)
-					} else {
-						content, metadata, err := parseMarkdownContent(filePath, queryType)
-						if err != nil {
-							return err
-						}
-						results = append(results, content...)
-						for range content {
-							metadataList = append(metadataList, metadata)
-						}
-					}
-				}
-				return nil
-			})
+	for _, path := range files {
+		if ast.Type == LIST {
+			_, metadata, err := parseMarkdownContent(path, ast)
 			if err != nil {
 				return nil, nil, err
 			}
-		} else {
-			if queryType == LIST {
+			if metadata != nil {
 				results = append(results, "- "+filepath.Base(path))
-				_, metadata, err := parseMarkdownContent(path, queryType)
-				if err != nil {
-					return nil, nil, err
-				}
 				metadataList = append(metadataList, metadata)
-			} else {
-				content, metadata, err := parseMarkdownContent(path, queryType)
-				if err != nil {
-					return nil, nil, err
-				}
-				results = append(results, content...)
-				for range content {
-					metadataList = append(metadataList, metadata)
-				}
+			}
+		} else {
+			content, metadata, err := parseMarkdownContent(path, ast)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, item := range content {
+				results = append(results, item.Text)
+				metadataList = append(metadataList, itemMetadata(metadata, item))
 			}
 		}
 	}
@@ -1212,44 +923,22 @@ func parseMarkdownFiles(paths []string, queryType QueryType) ([]string, []Metada
 	return results, metadataList, nil
 }
 
-func isUnorderedListItem(line string) bool {
-	trimmedLine := strings.TrimSpace(line)
-	return (strings.HasPrefix(trimmedLine, "- ") || strings.HasPrefix(trimmedLine, "* ")) &&
-		!strings.HasPrefix(trimmedLine, "- [ ]") &&
-		!strings.HasPrefix(trimmedLine, "- [.]") &&
-		!strings.HasPrefix(trimmedLine, "- [o]") &&
-		!strings.HasPrefix(trimmedLine, "- [O]") &&
-		!strings.HasPrefix(trimmedLine, "- [0]") &&
-		!strings.HasPrefix(trimmedLine, "- [x]") &&
-		!strings.HasPrefix(trimmedLine, "- [X]")
-}
-
-func isOrderedListItem(line string) bool {
-	trimmedLine := strings.TrimSpace(line)
-	if len(trimmedLine) == 0 || !unicode.IsNumber(rune(trimmedLine[0])) {
+// isTaskListItem checks if a list item starts with a task marker like "[ ]"
+// or "[x]"
+func isTaskListItem(item *Block) bool {
+	if len(item.Children) == 0 {
 		return false
 	}
-
-	for i, char := range trimmedLine {
-		if char == ' ' && i > 0 && trimmedLine[i-1] == '.' {
-			return true
-		}
-		if !unicode.IsNumber(char) && char != '.' {
-			return false
-		}
+	first := item.Children[0]
+	if first.Type != BLOCK_PARAGRAPH || first.StartLine != item.StartLine {
+		return false
 	}
-	return false
-}
 
-func isTaskListItem(line string) bool {
-	trimmedLine := strings.TrimSpace(line)
-	return strings.HasPrefix(trimmedLine, "- [ ]") ||
-		strings.HasPrefix(trimmedLine, "- [x]") ||
-		strings.HasPrefix(trimmedLine, "- [X]") ||
-		strings.HasPrefix(trimmedLine, "- [.]") ||
-		strings.HasPrefix(trimmedLine, "- [o]") ||
-		strings.HasPrefix(trimmedLine, "- [O]") ||
-		strings.HasPrefix(trimmedLine, "- [0]")
+	text := strings.TrimLeft(first.Content[0], " \t")
+	if len(text) < 3 || text[0] != '[' || text[2] != ']' || !slices.Contains(config.TaskStates, text[1:2]) {
+		return false
+	}
+	return len(text) == 3 || text[3] == ' ' || text[3] == '\t'
 }
 
 func applyConditions(item string, metadata Metadata, conditions []ConditionNode) bool {
@@ -1271,13 +960,18 @@ func applyConditions(item string, metadata Metadata, conditions []ConditionNode)
 		}
 
 		switch condition.Function {
+		case "<", "<=", ">", ">=":
+			conditionMet = compareValues(fieldValue, condition.Value, condition.Function)
 		case "CONTAINS":
 			conditionMet = strings.Contains(strings.ToLower(fieldValue), strings.ToLower(condition.Value))
 		case "IS":
 			conditionMet = fieldValue == condition.Value
 		case "CHECKED":
-			isChecked := strings.Contains(fieldValue, "[x]") || strings.Contains(fieldValue, "[X]")
-			conditionMet = isChecked
+			for _, state := range config.DoneStates {
+				if strings.Contains(fieldValue, "["+state+"]") {
+					conditionMet = true
+				}
+			}
 		}
 
 		if condition.IsNegated {
@@ -1296,12 +990,129 @@ func applyConditions(item string, metadata Metadata, conditions []ConditionNode)
 	return result
 }
 
-func filterContent(content []string, metadata []Metadata, conditions []ConditionNode) ([]string, []Metadata) {
+// applyWhere checks if an item matches every WHERE clause of a query
+func applyWhere(item string, metadata Metadata, where *WhereNode) bool {
+	for _, clause := range where.clauses() {
+		if !applyConditions(item, metadata, clause.Conditions) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareStrings compares two values numerically if both are numbers and
+// falls back to a string comparison otherwise
+func compareStrings(a string, b string) int {
+	num1, err1 := strconv.ParseFloat(a, 64)
+	num2, err2 := strconv.ParseFloat(b, 64)
+	if err1 == nil && err2 == nil {
+		if num1 < num2 {
+			return -1
+		} else if num1 > num2 {
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a, b)
+}
+
+func compareValues(a string, b string, operator string) bool {
+	// Missing values never match a comparison
+	if a == "" {
+		return false
+	}
+
+	compareResult := compareStrings(a, b)
+	switch operator {
+	case "<":
+		return compareResult < 0
+	case "<=":
+		return compareResult <= 0
+	case ">":
+		return compareResult > 0
+	case ">=":
+		return compareResult >= 0
+	}
+	return false
+}
+
+// sortByMetadata sorts the content (and its metadata) by the given metadata
+// fields, comparing numbers numerically
+func sortByMetadata(content []string, metadataList []Metadata, sorts []SortNode) {
+	if len(sorts) == 0 {
+		return
+	}
+
+	indices := make([]int, len(content))
+	for i := range indices {
+		indices[i] = i
+	}
+
+	sort.SliceStable(indices, func(i, j int) bool {
+		for _, sortNode := range sorts {
+			var val1, val2 string
+			if value, ok := metadataList[indices[i]][sortNode.Metadata]; ok {
+				val1 = fmt.Sprintf("%v", value)
+			}
+			if value, ok := metadataList[indices[j]][sortNode.Metadata]; ok {
+				val2 = fmt.Sprintf("%v", value)
+			}
+			compareResult := compareStrings(val1, val2)
+			if compareResult != 0 {
+				if sortNode.SortDirection == "DESC" {
+					return compareResult > 0
+				}
+				return compareResult < 0
+			}
+		}
+		return false
+	})
+
+	sortedContent := make([]string, len(content))
+	sortedMetadata := make([]Metadata, len(metadataList))
+	for i, index := range indices {
+		sortedContent[i] = content[index]
+		sortedMetadata[i] = metadataList[index]
+	}
+	copy(content, sortedContent)
+	copy(metadataList, sortedMetadata)
+}
+
+// sortByText sorts the content (and its metadata) by the text of the results
+// in natural order
+func sortByText(content []string, metadataList []Metadata, direction string) {
+	indices := make([]int, len(content))
+	for i := range indices {
+		indices[i] = i
+	}
+
+	sort.SliceStable(indices, func(i, j int) bool {
+		if direction == "DESC" {
+			return NaturalSort(content[indices[i]], content[indices[j]])
+		}
+		return NaturalSort(content[indices[j]], content[indices[i]])
+	})
+
+	sortedContent := make([]string, len(content))
+	sortedMetadata := make([]Metadata, len(metadataList))
+	for i, index := range indices {
+		sortedContent[i] = content[index]
+		sortedMetadata[i] = metadataList[index]
+	}
+	copy(content, sortedContent)
+	copy(metadataList, sortedMetadata)
+}
+
+func filterContent(content []string, metadata []Metadata, where *WhereNode) ([]string, []Metadata) {
+	if where == nil {
+		return content, metadata
+	}
+
 	var filteredContent []string
 	var filteredMetadata []Metadata
 
 	for i, item := range content {
-		if applyConditions(item, metadata[i], conditions) {
+		if applyWhere(item, metadata[i], where) {
 			filteredContent = append(filteredContent, item)
 			filteredMetadata = append(filteredMetadata, metadata[i])
 		}
@@ -1310,6 +1121,40 @@ func filterContent(content []string, metadata []Metadata, conditions []Condition
 	return filteredContent, filteredMetadata
 }
 
+// distinctContent keeps the first of the results with the same text, or the
+// same value of the given field. Results without the field count as one
+// value.
+func distinctContent(content []string, metadata []Metadata, field string) ([]string, []Metadata) {
+	var distinct []string
+	var distinctMetadata []Metadata
+	seen := make(map[string]bool)
+
+	for i, item := range content {
+		key := distinctKey(item, metadata[i], field)
+		if !seen[key] {
+			seen[key] = true
+			distinct = append(distinct, item)
+			distinctMetadata = append(distinctMetadata, metadata[i])
+		}
+	}
+
+	return distinct, distinctMetadata
+}
+
+// distinctKey returns what DISTINCT compares for a result: its text or the
+// value of the field
+func distinctKey(text string, metadata Metadata, field string) string {
+	if field == "" {
+		return text
+	}
+	value, ok := metadata[field]
+	if !ok {
+		// Can't be the text of a value
+		return "\x00"
+	}
+	return fmt.Sprintf("%v", value)
+}
+
 func readFromPipe() (string, error) {
 	bytes, err := io.ReadAll(os.Stdin)
 	if err != nil {
@@ -1319,14 +1164,21 @@ func readFromPipe() (string, error) {
 }
 
 func executeQuery(query string, showAST bool) (string, error) {
-	tokens := Lex(query)
+	tokens, err := bindVariables(Lex(query))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse query: %w", err)
+	}
 	ast, err := Parse(tokens)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse query: %w", err)
 	}
 
 	if showAST {
-		printTokens(tokens)
+		printAST(ast)
+	}
+
+	if ast.Explain {
+		return explainQuery(ast)
 	}
 
 	result, err := Interpret(ast)
@@ -1348,22 +1200,9 @@ func printMetadata(metadataList []Metadata) {
 	}
 }
 
-func printTokens(tokens []Token) {
-	type jsonToken struct {
-		Type  string `json:"Type"`
-		Value string `json:"Value"`
-	}
-
-	var jsonTokens []jsonToken
-
-	for _, token := range tokens {
-		jsonTokens = append(jsonTokens, jsonToken{
-			Type:  TokenTypeNames[token.Type],
-			Value: token.Value,
-		})
-	}
-
-	jsonData, err := json.MarshalIndent(jsonTokens, "", "  ")
+// printAST prints the parsed query as JSON
+func printAST(ast *QueryNode) {
+	jsonData, err := json.MarshalIndent(ast, "", "  ")
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -1373,15 +1212,76 @@ func printTokens(tokens []Token) {
 }
 
 var printMetadataFlag bool
+var outlineFlag bool
+var fencesFlag bool
+var noIgnoreFlag bool
+
+// addQueryFlags registers the flags changing how queries are run and printed
+func addQueryFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&printMetadataFlag, "metadata", false, "print metadata as JSON")
+	flags.BoolVar(&outlineFlag, "outline", false, "print HEADING results as an outline indented by level")
+	flags.BoolVar(&fencesFlag, "fences", false, "print FENCEDCODE results with their fences and info string")
+	flags.IntVar(&pageFlag, "page", 0, "print the given page of results as JSON with the total counts (starting at 1)")
+	flags.IntVar(&pageSizeFlag, "page-size", pageSizeFlag, "number of results (or groups) on a page")
+	addSourceFlags(flags)
+}
+
+// addSourceFlags registers the flags changing which files a query reads and
+// how its variables are bound
+func addSourceFlags(flags *flag.FlagSet) {
+	flags.StringVar(&extensionsFlag, "ext", "", "comma separated list of the file extensions picked up in directories (default \".md,.markdown,.mdx,.qmd\" or the configured extensions)")
+	flags.StringVar(&rootFlag, "root", "", "root directory of the vault that FROM paths are resolved against")
+	flags.BoolVar(&strictPathsFlag, "strict-paths", false, "refuse paths that escape the vault root through .. or symlinks")
+	flags.BoolVar(&noIgnoreFlag, "no-ignore", false, "don't skip files ignored by .gitignore and .dynomarkignore files")
+	flags.Func("var", "bind a query variable as name=value (can be repeated)", parseVariableFlag)
+	flags.StringVar(&contextFileFlag, "context-file", "", "the note a query belongs to, used by the $this.* variables")
+}
 
 func main() {
 	var query string
 	var err error
+
+	// Subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "tangle":
+			if err := runTangle(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		case "run":
+			if err := runSavedQuery(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		case "repl":
+			if err := runRepl(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		case "fmt":
+			if err := runFmt(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		case "lint":
+			if err := runLint(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+	}
+
 	versionFlag := flag.Bool("v", false, "print the version number")
 	longVersionFlag := flag.Bool("version", false, "print the version number")
 
-	ShowASTFlag := flag.Bool("ast", false, "print the whole AST before showing the results")
-	flag.BoolVar(&printMetadataFlag, "metadata", false, "print metadata as JSON")
+	ShowASTFlag := flag.Bool("ast", false, "print the parsed query as JSON before showing the results")
+	addQueryFlags(flag.CommandLine)
 
 	flag.StringVar(&query, "query", "", "The query string to be processe")
 	flag.StringVar(&query, "q", "", "The query string to be processed (shorthand)")
@@ -1393,6 +1293,15 @@ func main() {
 		os.Exit(0)
 	}
 
+	if err := resolveVaultRoot(rootFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := loadConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	stat, _ := os.Stdin.Stat()
 	if (stat.Mode() & os.ModeCharDevice) == 0 {
 		if query == "" {
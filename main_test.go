@@ -1,7 +1,15 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 type TestQuery struct {
@@ -23,6 +31,20 @@ func runTestQueries(t *testing.T, queries []TestQuery) {
 	}
 }
 
+// writeFiles creates files with the given content in dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
 func TestListQueries(t *testing.T) {
 	queries := []TestQuery{
 		{
@@ -69,6 +91,7 @@ consectetur cupidatat.`,
 			name:  "PARAGRAPH query with a single file and a condition",
 			query: "PARAGRAPH FROM \"examples/misc/movie_reviews.md\" WHERE CONTAINS \"Generic\"",
 			expected: `**Generic race movie**
+
 **Generic Action movie**`,
 		},
 		{
@@ -76,12 +99,34 @@ consectetur cupidatat.`,
 			query: "PARAGRAPH FROM \"examples/misc/movie_reviews.md\" WHERE NOT CONTAINS \"Lorem\"",
 			expected: `**Generic race movie**
 
-
 **Generic Action movie**
 
-
 What's cool:`,
 		},
+		{
+			name:  "PARAGRAPH query returns whole paragraphs for conditions and limits",
+			query: "PARAGRAPH FROM \"examples/misc/tasks.md\" WHERE CONTAINS \"consectetur\" LIMIT 1",
+			expected: `Lorem ipsum dolor sit amet, officia excepteur ex fugiat reprehenderit enim labore
+culpa sint ad nisi Lorem pariatur mollit ex esse exercitation amet. Nisi anim
+cupidatat excepteur officia. Reprehenderit nostrud nostrud ipsum Lorem est aliquip
+amet voluptate voluptate dolor minim nulla est proident. Nostrud officia pariatur
+ut officia. Sit irure elit esse ea nulla sunt ex occaecat reprehenderit commodo
+officia dolor Lorem duis laboris cupidatat officia voluptate. Culpa proident
+adipisicing id nulla nisi laboris ex in Lorem sunt duis officia eiusmod. Aliqua
+reprehenderit commodo ex non excepteur duis sunt velit enim. Voluptate laboris
+sint cupidatat ullamco ut ea consectetur et est culpa et culpa duis.`,
+		},
+		{
+			name:  "PARAGRAPH query with a condition on the line span",
+			query: "PARAGRAPH FROM \"examples/misc/tasks.md\" WHERE [item.line] IS 20 AND [item.end-line] IS 21",
+			expected: `Lorem ipsum dolor sit amet, qui minim labore adipisicing minim sint cillum sint
+consectetur cupidatat.`,
+		},
+		{
+			name:     "PARAGRAPH query with a condition on the section",
+			query:    "PARAGRAPH FROM \"examples/misc/movie_reviews.md\" WHERE [item.section] IS \"Movie reviews\" AND NOT CONTAINS \"Lorem\" LIMIT 2",
+			expected: "**Generic race movie**\n\n**Generic Action movie**",
+		},
 	}
 
 	runTestQueries(t, queries)
@@ -199,6 +244,15 @@ func TestUnorderedListQueries(t *testing.T) {
 - Item 2
 - Item 4`,
 		},
+		{
+			name:  "UNORDEREDLIST query with + and * bullets and a lazy continuation line",
+			query: "UNORDEREDLIST FROM \"examples/docs/syntax.md\"",
+			expected: `+ Plus bullet
++ Another plus bullet
+  with a lazy
+continuation line
+* Star bullet`,
+		},
 	}
 
 	runTestQueries(t, queries)
@@ -225,6 +279,12 @@ func TestOrderedListQueries(t *testing.T) {
    sorta
    long-ish`,
 		},
+		{
+			name:  "ORDEREDLIST query with ) markers",
+			query: "ORDEREDLIST FROM \"examples/docs/syntax.md\"",
+			expected: `1) First step
+2) Second step`,
+		},
 	}
 
 	runTestQueries(t, queries)
@@ -239,6 +299,46 @@ func TestFencedCodeQueries(t *testing.T) {
     fmt.Println("Hello, DynoMark!")
 }`,
 		},
+		{
+			name:  "FENCEDCODE query with tilde, nested and indented fences",
+			query: "FENCEDCODE FROM \"examples/docs/runbook.md\" WHERE NOT [lang] IS \"yaml\"",
+			expected: `package main
+func main() {}
+` + "```bash\nmake install\n```" + `
+echo "indented fence"`,
+		},
+		{
+			name:  "FENCEDCODE query with a condition on the language",
+			query: "FENCEDCODE FROM \"examples/\" WHERE [lang] IS \"go\"",
+			expected: `package main
+func main() {}
+func main() {
+    fmt.Println("Hello, DynoMark!")
+}`,
+		},
+		{
+			name:     "FENCEDCODE query with a condition on an attribute",
+			query:    "FENCEDCODE FROM \"examples/docs/\" WHERE [attr.title] IS \"Entry point\"",
+			expected: `package main`,
+		},
+	}
+
+	runTestQueries(t, queries)
+}
+
+func TestFencedCodeWithFences(t *testing.T) {
+	fencesFlag = true
+	defer func() { fencesFlag = false }()
+
+	queries := []TestQuery{
+		{
+			name:  "FENCEDCODE query printed with the original fences",
+			query: "FENCEDCODE FROM \"examples/docs/runbook.md\" WHERE [lang] IS \"md\" OR [lang] IS \"go\"",
+			expected: `~~~go {file="cmd/main.go" title="Entry point"}
+package main
+~~~
+` + "```go file=cmd/main.go\nfunc main() {}\n```\n````md\n```bash\nmake install\n```\n````",
+		},
 	}
 
 	runTestQueries(t, queries)
@@ -263,6 +363,60 @@ func TestTableQueries(t *testing.T) {
 	runTestQueries(t, queries)
 }
 
+func TestFrontmatter(t *testing.T) {
+	queries := []TestQuery{
+		{
+			name:  "TABLE query over YAML, TOML and JSON frontmatter",
+			query: "TABLE title AS \"Title\", draft AS \"Draft\", params.author AS \"Author\" FROM \"examples/hugo/\"",
+			expected: `| File           | Title       | Draft | Author   |
+|----------------|-------------|-------|----------|
+| first-post.md  | First post  | false | Jane Doe |
+| second-post.md | Second post | true  | John Doe |
+| third-post.md  | Third post  | false |          |
+`,
+		},
+		{
+			name:     "LIST query on a TOML array",
+			query:    "LIST FROM \"examples/hugo/\" WHERE [tags] CONTAINS \"markdown\"",
+			expected: `- first-post.md`,
+		},
+		{
+			name:     "Metadata after a thematic break is not frontmatter",
+			query:    "LIST FROM \"examples/hugo/\" WHERE [status] IS \"archived\"",
+			expected: `- third-post.md`,
+		},
+		{
+			name:  "PARAGRAPH query keeps the text around a thematic break",
+			query: "PARAGRAPH FROM \"examples/hugo/third-post.md\"",
+			expected: `Intro paragraph.
+
+status:: archived
+
+The line above is a thematic break, not frontmatter.`,
+		},
+		{
+			name:     "Frontmatter lines aren't counted as content",
+			query:    "HEADING FROM \"examples/hugo/\" WHERE [item.line] IS 8",
+			expected: `# Second post`,
+		},
+	}
+
+	runTestQueries(t, queries)
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"wip.md": "+++\ntitle = \"Issue #12\" # quoted hashes stay\ndraft = true # wip\ntags = [\"a\", \"b\"] # two tags\n[params] # author\nauthor = 'Jane'\n+++\n",
+	})
+	runTestQueries(t, []TestQuery{{
+		name:  "TOML frontmatter with trailing comments",
+		query: fmt.Sprintf("TABLE title, tags, params.author FROM \"%s\" WHERE [draft] IS \"true\"", dir),
+		expected: `| File   | title     | tags | params.author |
+|--------|-----------|------|---------------|
+| wip.md | Issue #12 | a, b | Jane          |
+`,
+	}})
+}
+
 func TestTableNoIdQueries(t *testing.T) {
 	queries := []TestQuery{
 		{
@@ -281,3 +435,1301 @@ func TestTableNoIdQueries(t *testing.T) {
 
 	runTestQueries(t, queries)
 }
+
+func TestHeadingQueries(t *testing.T) {
+	queries := []TestQuery{
+		{
+			name:  "HEADING query with a single file",
+			query: "HEADING FROM \"examples/docs/guide.md\"",
+			expected: `# User guide
+## Installation
+### From source
+## Configuration
+## Usage`,
+		},
+		{
+			name:  "HEADING query with a level filter",
+			query: "HEADING FROM \"examples/docs/guide.md\" WHERE [level] <= 2",
+			expected: `# User guide
+## Installation
+## Configuration
+## Usage`,
+		},
+		{
+			name:     "HEADING query with a level filter and a condition",
+			query:    "HEADING FROM \"examples/docs/guide.md\" WHERE [level] > 1 AND CONTAINS \"source\"",
+			expected: `### From source`,
+		},
+	}
+
+	runTestQueries(t, queries)
+}
+
+func TestHeadingOutline(t *testing.T) {
+	outlineFlag = true
+	defer func() { outlineFlag = false }()
+
+	queries := []TestQuery{
+		{
+			name:  "HEADING query rendered as an outline",
+			query: "HEADING FROM \"examples/docs/guide.md\"",
+			expected: `- User guide
+  - Installation
+    - From source
+  - Configuration
+  - Usage`,
+		},
+	}
+
+	runTestQueries(t, queries)
+}
+
+func TestSectionQueries(t *testing.T) {
+	queries := []TestQuery{
+		{
+			name:  "SECTION query on a directory",
+			query: "SECTION \"Decisions\" FROM \"examples/meetings/\"",
+			expected: `- Move the release to Friday
+- Drop support for the legacy API
+
+### Follow-up
+
+Alice will send the release notes.
+
+Keep the current color palette.`,
+		},
+		{
+			name:  "SECTION query with only the direct body of the section",
+			query: "SECTION DIRECT \"decisions\" FROM \"examples/meetings/\" WHERE [team] IS \"Platform\"",
+			expected: `- Move the release to Friday
+- Drop support for the legacy API`,
+		},
+		{
+			name:  "SECTION query grouped by file name",
+			query: "SECTION DIRECT \"Decisions\" FROM \"examples/meetings/\" GROUP BY [file.name]",
+			expected: `- 2025-01-06.md
+    - Move the release to Friday
+    - Drop support for the legacy API
+
+- 2025-01-13.md
+    Keep the current color palette.
+
+`,
+		},
+		{
+			name:  "SECTION query with a heading that starts with an emoji",
+			query: "SECTION \"Deployment\" FROM \"examples/todos/todo-project.md\"",
+			expected: `- [.] Setup CI/CD pipeline (GitHub Actions)
+- [ ] Dev/Staging/Production environments
+- [ ] Final smoke test
+- [ ] Launch!`,
+		},
+	}
+
+	runTestQueries(t, queries)
+}
+
+func TestInSectionQueries(t *testing.T) {
+	queries := []TestQuery{
+		{
+			name:  "TASK query scoped to a section",
+			query: "TASK FROM \"examples/misc/tasks.md\" IN SECTION \"More todos\"",
+			expected: `- [X] Task 5
+- [ ] Task 6
+- [ ] Task 7
+- [X] Task 8`,
+		},
+		{
+			name:  "UNORDEREDLIST query scoped to a section with nested subheadings",
+			query: "UNORDEREDLIST FROM \"examples/misc/test.md\" IN SECTION \"Lists\" WHERE NOT CONTAINS \"really\"",
+			expected: `- Item 1
+- Item 2
+- Item 4`,
+		},
+		{
+			name:  "TASK query scoped to a section with an emoji in the heading",
+			query: "TASK FROM \"examples/todos/\" IN SECTION \"QA & Testing\" WHERE NOT CHECKED",
+			expected: `- [.] Unit test coverage (target: 90%)
+- [ ] End-to-end tests (Playwright)
+- [o] QA checklist document
+- [ ] Accessibility audit`,
+		},
+		{
+			name:  "TASK query grouped by heading path",
+			query: "TASK FROM \"examples/misc/\" WHERE CHECKED GROUP BY [item.heading-path]",
+			expected: `- Some todos
+    - [X] Task 2
+    - [X] Task 4
+
+- Some todos > More todos
+    - [X] Task 5
+    - [X] Task 8
+
+- Test Markdown File > Tasks
+    - [x] Create test markdown file
+    - [x] Design CLI interface
+
+`,
+		},
+		{
+			name:  "LIST query of files that contain a section",
+			query: "LIST FROM \"examples/\" IN SECTION \"Decisions\"",
+			expected: `- 2025-01-06.md
+- 2025-01-13.md`,
+		},
+	}
+
+	runTestQueries(t, queries)
+}
+
+func TestBlockquoteQueries(t *testing.T) {
+	queries := []TestQuery{
+		{
+			name:  "BLOCKQUOTE query with a single file and a condition",
+			query: "BLOCKQUOTE FROM \"examples/docs/deployment.md\" WHERE CONTAINS \"Friday\"",
+			expected: `> Deploying on a Friday is always a bad idea.
+> -- Everyone`,
+		},
+		{
+			name:  "BLOCKQUOTE query keeps a blank line between blockquotes",
+			query: "BLOCKQUOTE FROM \"examples/docs/deployment.md\" WHERE NOT CONTAINS \"WARNING\"",
+			expected: `> Deploying on a Friday is always a bad idea.
+> -- Everyone
+
+> [!note]
+> The deploy script needs access to the production cluster.`,
+		},
+	}
+
+	runTestQueries(t, queries)
+}
+
+func TestCalloutQueries(t *testing.T) {
+	queries := []TestQuery{
+		{
+			name:  "CALLOUT query filtered by callout type",
+			query: "CALLOUT FROM \"examples/docs/\" WHERE [callout.type] IS \"warning\"",
+			expected: `> [!WARNING] Database migrations
+> Always back up the database before running migrations.
+
+> [!WARNING]- Rollbacks
+> Rollbacks are not automatic.`,
+		},
+		{
+			name:  "CALLOUT query filtered by folding state",
+			query: "CALLOUT FROM \"examples/docs/\" WHERE [callout.fold] IS \"closed\"",
+			expected: `> [!WARNING]- Rollbacks
+> Rollbacks are not automatic.`,
+		},
+		{
+			name:  "CALLOUT query grouped by the default title",
+			query: "CALLOUT FROM \"examples/docs/\" WHERE [callout.type] IS \"note\" GROUP BY [callout.title]",
+			expected: `- Note
+    > [!note]
+    > The deploy script needs access to the production cluster.
+
+`,
+		},
+	}
+
+	runTestQueries(t, queries)
+}
+
+func TestLinkQueries(t *testing.T) {
+	queries := []TestQuery{
+		{
+			name:  "LINK query with a single file",
+			query: "LINK FROM \"examples/notes/links.md\"",
+			expected: `[Go blog](https://go.dev/blog "The Go Blog")
+[project board][jira]
+[[Meeting notes|the meeting notes]]
+[setup guide](../docs/guide.md)
+<team@example.com>
+<https://example.com>`,
+		},
+		{
+			name:     "LINK query with a condition on the target",
+			query:    "LINK FROM \"examples/notes/\" WHERE [target] CONTAINS \"jira\"",
+			expected: `[project board][jira]`,
+		},
+		{
+			name:  "LINK query for internal links",
+			query: "LINK FROM \"examples/notes/\" WHERE [internal] IS \"true\"",
+			expected: `[[Meeting notes|the meeting notes]]
+[setup guide](../docs/guide.md)`,
+		},
+		{
+			name:     "LINK query with a condition on the line number",
+			query:    "LINK FROM \"examples/notes/\" WHERE [item.line] >= 11 AND [link-type] IS \"autolink\" AND [target] CONTAINS \"mailto\"",
+			expected: `<team@example.com>`,
+		},
+	}
+
+	runTestQueries(t, queries)
+}
+
+func TestImageQueries(t *testing.T) {
+	queries := []TestQuery{
+		{
+			name:  "IMAGE query with a single file",
+			query: "IMAGE FROM \"examples/notes/links.md\"",
+			expected: `![Architecture diagram](images/architecture.png)
+![](images/missing-alt.png)`,
+		},
+		{
+			name:     "IMAGE query for images without alt text",
+			query:    "IMAGE FROM \"examples/notes/\" WHERE [text] IS \"\"",
+			expected: `![](images/missing-alt.png)`,
+		},
+	}
+
+	runTestQueries(t, queries)
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"badges.md": "[![Build](badge.svg)](https://ci.example.com) [![Docs][docs-badge]][docs]\n\n[docs-badge]: docs.svg\n[docs]: https://docs.example.com",
+	})
+	runTestQueries(t, []TestQuery{
+		{
+			name:  "IMAGE query for badges nested in links",
+			query: fmt.Sprintf("IMAGE FROM \"%s\"", dir),
+			expected: `![Build](badge.svg)
+![Docs][docs-badge]`,
+		},
+		{
+			name:     "LINK text of a badge is its alt text",
+			query:    fmt.Sprintf("LINK FROM \"%s\" WHERE [text] IS \"Build\"", dir),
+			expected: `[![Build](badge.svg)](https://ci.example.com)`,
+		},
+	})
+}
+
+func TestNestedBracketsPerformance(t *testing.T) {
+	doc := parseDocument([]string{"[docs]: https://docs.example.com"})
+	lines := []string{
+		strings.Repeat("[a ", 5000) + strings.Repeat("]", 5000),
+		strings.Repeat("[a ", 5000) + strings.Repeat("] ", 5000),
+		strings.Repeat("![a ", 5000) + strings.Repeat("]", 5000),
+		strings.Repeat("[a ", 2000) + strings.Repeat("](u)", 2000),
+		strings.Repeat("[a ", 20000) + "[docs]",
+		strings.Repeat("[[", 20000) + "a",
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for i, line := range lines {
+			parseInlineLinks(doc, line, i)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Parsing nested brackets took too long")
+	}
+}
+
+func TestMarkdownTableQueries(t *testing.T) {
+	queries := []TestQuery{
+		{
+			name:  "MDTABLE query with a single file",
+			query: "MDTABLE FROM \"examples/logs/reading-2024.md\"",
+			expected: `| Book                                  | Author              | Pages | Status  |
+|:--------------------------------------|:--------------------|------:|:-------:|
+| The Go Programming Language           | Donovan & Kernighan | 380   | done    |
+| Crafting Interpreters                 | Robert Nystrom      | 640   | reading |
+| Designing Data-Intensive Applications | Martin Kleppmann    | 616   | done    |`,
+		},
+		{
+			name:  "MDTABLE query merging tables with a condition on a column",
+			query: "MDTABLE FROM \"examples/logs/\" WHERE [Status] IS \"done\"",
+			expected: `| Book                                              | Author              | Pages | Status | Rating |
+|:--------------------------------------------------|:--------------------|------:|:------:|--------|
+| The Go Programming Language                       | Donovan & Kernighan | 380   | done   |        |
+| Designing Data-Intensive Applications             | Martin Kleppmann    | 616   | done   |        |
+| Structure and Interpretation of Computer Programs | Abelson & Sussman   | 657   | done   | 5      |
+| The Pragmatic Programmer                          | Hunt & Thomas       | 352   | done   | 4      |`,
+		},
+		{
+			name:  "MDTABLE query sorted by a numeric column with a limit",
+			query: "MDTABLE FROM \"examples/logs/\" SORT [Pages] DESC LIMIT 2",
+			expected: `| Book                                              | Author            | Pages | Status  | Rating |
+|---------------------------------------------------|-------------------|------:|:-------:|--------|
+| Structure and Interpretation of Computer Programs | Abelson & Sussman | 657   | done    | 5      |
+| Crafting Interpreters                             | Robert Nystrom    | 640   | reading |        |`,
+		},
+	}
+
+	runTestQueries(t, queries)
+}
+
+func TestTangle(t *testing.T) {
+	dir := t.TempDir()
+	ast := &QueryNode{Type: FENCEDCODE, From: []string{"examples/docs/runbook.md"}, Limit: -1}
+
+	report, err := tangleFiles(ast, dir, false)
+	if err != nil {
+		t.Fatalf("Error tangling files: %v", err)
+	}
+	if !strings.Contains(report, "(2 blocks from examples/docs/runbook.md)") {
+		t.Errorf("Unexpected report:\n%s", report)
+	}
+
+	main, err := os.ReadFile(filepath.Join(dir, "cmd", "main.go"))
+	if err != nil {
+		t.Fatalf("Error reading tangled file: %v", err)
+	}
+	if string(main) != "package main\nfunc main() {}\n" {
+		t.Errorf("Unexpected content of cmd/main.go:\n%s", main)
+	}
+
+	// Tangling again shouldn't change anything
+	report, err = tangleFiles(ast, dir, false)
+	if err != nil {
+		t.Fatalf("Error tangling files: %v", err)
+	}
+	if strings.Count(report, "unchanged") != 2 {
+		t.Errorf("Expected all files to be unchanged, got:\n%s", report)
+	}
+}
+
+func TestTangleStages(t *testing.T) {
+	ast, err := Parse(Lex(`FENCEDCODE FROM "examples/docs/runbook.md" LIMIT 1`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := tangleFiles(ast, t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("Error tangling files: %v", err)
+	}
+	if !strings.Contains(report, "app.yaml (1 blocks from examples/docs/runbook.md)") || strings.Contains(report, "main.go") {
+		t.Errorf("Expected LIMIT 1 to only tangle the first block, got:\n%s", report)
+	}
+}
+
+func TestTangleConflicts(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.md", "b.md"} {
+		content := "```sh file=setup.sh\necho " + name + "\n```\n\n```sh file=../escape.sh\necho\n```\n"
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ast := &QueryNode{Type: FENCEDCODE, From: []string{dir}, Limit: -1}
+	_, err := tangleFiles(ast, "", false)
+	if err == nil {
+		t.Fatal("Expected conflicts, got none")
+	}
+	if !strings.Contains(err.Error(), "found 3 conflicts") || !strings.Contains(err.Error(), "written by multiple files") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "setup.sh")); !os.IsNotExist(err) {
+		t.Errorf("Conflicting file shouldn't be written")
+	}
+}
+
+func TestFromGlobsAndExcludes(t *testing.T) {
+	queries := []TestQuery{
+		{
+			name:  "LIST query with a ** glob",
+			query: "LIST FROM \"examples/**/2025-*.md\"",
+			expected: `- 2025-01-06.md
+- 2025-01-13.md`,
+		},
+		{
+			name:     "LIST query with a glob and an excluded directory",
+			query:    "LIST FROM \"examples/**/*2025*.md\" EXCLUDE \"meetings/\"",
+			expected: `- reading-2025.md`,
+		},
+		{
+			name:  "LIST query with an EXCLUDE clause",
+			query: "LIST FROM \"examples/misc/\" EXCLUDE \"tasks.md\"",
+			expected: `- movie_reviews.md
+- test.md`,
+		},
+		{
+			name:  "LIST query with negated paths",
+			query: "LIST FROM \"examples/todos/\" \"-todo-long.md\" \"-examples/todos/todo-nested.md\"",
+			expected: `- todo-basic.md
+- todo-project.md
+- todo-states.md`,
+		},
+		{
+			name:  "TABLE query with an EXCLUDE clause",
+			query: "TABLE NO ID title AS \"Title\" FROM \"examples/hugo/\" EXCLUDE \"second-*\"",
+			expected: `| Title      |
+|------------|
+| First post |
+| Third post |
+`,
+		},
+	}
+
+	runTestQueries(t, queries)
+}
+
+func TestExcludePatterns(t *testing.T) {
+	tests := []struct {
+		path     string
+		isDir    bool
+		pattern  string
+		excluded bool
+	}{
+		{"notes/archive", true, "archive/", true},
+		{"notes/archive/old.md", false, "archive/", true},
+		{"notes/archive.md", false, "archive/", false},
+		{"notes/templates/daily.md", false, "templates", true},
+		{"notes/daily.template.md", false, "*.template.md", true},
+		{"notes/2025/archive/old.md", false, "notes/**/archive", true},
+		{"other/archive/old.md", false, "notes/archive", false},
+		{"./notes/archive", true, "notes/archive/", true},
+	}
+
+	for _, test := range tests {
+		if got := isExcluded(test.path, test.isDir, []string{test.pattern}); got != test.excluded {
+			t.Errorf("isExcluded(%q, %q) = %v, expected %v", test.path, test.pattern, got, test.excluded)
+		}
+	}
+}
+
+func TestIgnoreFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		".gitignore":                 "node_modules/\n/build\n*.tmp.md\ndrafts/*\n!drafts/keep.md\n",
+		".dynomarkignore":            "templates/\n",
+		"notes/.gitignore":           "private.md\n",
+		"notes/today.md":             "- [ ] Today",
+		"notes/private.md":           "- [ ] Private",
+		"notes/build/output.md":      "- [ ] Nested build",
+		"notes/scratch.tmp.md":       "- [ ] Scratch",
+		"drafts/keep.md":             "- [ ] Keep",
+		"drafts/skip.md":             "- [ ] Skip",
+		"build/output.md":            "- [ ] Build",
+		"node_modules/pkg/README.md": "- [ ] Vendored",
+		"templates/daily.md":         "- [ ] Template",
+		".git/info.md":               "- [ ] Git",
+		"archive/.dynomarkignore":    "*\n!keep.md\n",
+		"archive/keep.md":            "- [ ] Archived but kept",
+		"archive/old.md":             "- [ ] Archived",
+	}
+	writeFiles(t, dir, files)
+
+	query := fmt.Sprintf("TASK FROM \"%s\"", dir)
+	runTestQueries(t, []TestQuery{{
+		name:  "TASK query skipping ignored files",
+		query: query,
+		expected: `- [ ] Archived but kept
+- [ ] Keep
+- [ ] Nested build
+- [ ] Today`,
+	}})
+
+	noIgnoreFlag = true
+	defer func() { noIgnoreFlag = false }()
+
+	result, err := executeQuery(query, false)
+	if err != nil {
+		t.Fatalf("Error executing query: %v", err)
+	}
+	if strings.Count(result, "- [ ]") != len(files)-4 {
+		t.Errorf("Expected every file to be queried with -no-ignore, got:\n%s", result)
+	}
+}
+
+func TestFileExtensions(t *testing.T) {
+	runTestQueries(t, []TestQuery{
+		{
+			name:  "LIST query over every default extension",
+			query: "LIST FROM \"examples/variants/\"",
+			expected: `- analysis.qmd
+- component-guide.mdx
+- release-notes.markdown`,
+		},
+		{
+			name:  "TABLE query on file.shortname and file.ext",
+			query: "TABLE file.shortname, file.ext FROM \"examples/variants/\"",
+			expected: `| File                   | file.shortname  | file.ext |
+|------------------------|-----------------|----------|
+| analysis.qmd           | analysis        | qmd      |
+| component-guide.mdx    | component-guide | mdx      |
+| release-notes.markdown | release-notes   | markdown |
+`,
+		},
+		{
+			name:  "PARAGRAPH query skips MDX imports, JSX and expressions",
+			query: "PARAGRAPH FROM \"examples/variants/component-guide.mdx\"",
+			expected: `Components are written in JSX and rendered at build time.
+
+Markdown inside a component is still part of the page.
+
+See <https://mdxjs.com> for the full syntax.`,
+		},
+		{
+			name:     "JSX in MDX code blocks is kept",
+			query:    "FENCEDCODE FROM \"examples/variants/\" WHERE [lang] IS \"jsx\"",
+			expected: `<Callout>Code blocks keep their JSX</Callout>`,
+		},
+		{
+			name:  "Language of a Quarto code chunk",
+			query: "FENCEDCODE FROM \"examples/variants/\" WHERE [lang] IS \"python\"",
+			expected: `#| label: load-data
+import pandas as pd
+builds = pd.read_csv("builds.csv")`,
+		},
+	})
+
+	extensionsFlag = "markdown, QMD"
+	defer func() { extensionsFlag = "" }()
+
+	runTestQueries(t, []TestQuery{{
+		name:  "LIST query with custom extensions",
+		query: "LIST FROM \"examples/variants/\"",
+		expected: `- analysis.qmd
+- release-notes.markdown`,
+	}})
+
+	extensionsFlag = ""
+	config.Extensions = []string{"qmd"}
+	defer func() { config = defaultConfig() }()
+	runTestQueries(t, []TestQuery{{
+		name:     "LIST query with the configured extensions",
+		query:    "LIST FROM \"examples/variants/\"",
+		expected: `- analysis.qmd`,
+	}})
+
+	extensionsFlag = ".markdown"
+	runTestQueries(t, []TestQuery{{
+		name:     "-ext takes precedence over the configured extensions",
+		query:    "LIST FROM \"examples/variants/\"",
+		expected: `- release-notes.markdown`,
+	}})
+}
+
+func TestMDXStatementsAtEndOfFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"import.mdx": "import X from \"y\"\n",
+		"export.mdx": "# Guide\n\nSome text.\n\nexport const meta = {a: 1}",
+	})
+
+	runTestQueries(t, []TestQuery{
+		{
+			name:     "MDX file with only an import",
+			query:    fmt.Sprintf("PARAGRAPH FROM \"%s/import.mdx\"", dir),
+			expected: ``,
+		},
+		{
+			name:     "MDX file ending with an export",
+			query:    fmt.Sprintf("PARAGRAPH FROM \"%s/export.mdx\"", dir),
+			expected: `Some text.`,
+		},
+	})
+}
+
+func TestVaultRoot(t *testing.T) {
+	outside := t.TempDir()
+	dir := t.TempDir()
+	files := map[string]string{
+		".dynomarkroot":         "",
+		"notes/today.md":        "- [ ] Today",
+		"notes/2025/january.md": "- [ ] January",
+		"notes/archive/old.md":  "- [ ] Archived",
+	}
+	writeFiles(t, dir, files)
+	if err := os.WriteFile(filepath.Join(outside, "secret.md"), []byte("- [ ] Secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.md"), filepath.Join(dir, "notes", "secret.md")); err != nil {
+		t.Skipf("Symlinks aren't supported: %v", err)
+	}
+
+	if root := findVaultRoot(filepath.Join(dir, "notes", "2025")); root != dir {
+		t.Errorf("Expected vault root %s, got %s", dir, root)
+	}
+
+	vaultRoot = dir
+	defer func() { vaultRoot = "" }()
+
+	runTestQueries(t, []TestQuery{
+		{
+			name:  "TABLE query with paths relative to the vault root",
+			query: "TABLE file.path, file.link FROM \"notes/\" EXCLUDE \"notes/archive/\"",
+			expected: `| File       | file.path             | file.link                           |
+|------------|-----------------------|-------------------------------------|
+| january.md | notes/2025/january.md | [january.md](notes/2025/january.md) |
+| secret.md  | notes/secret.md       | [secret.md](notes/secret.md)        |
+| today.md   | notes/today.md        | [today.md](notes/today.md)          |
+`,
+		},
+		{
+			name:     "Paths outside of the vault are allowed without strict mode",
+			query:    "TASK FROM \"../" + filepath.Base(outside) + "/secret.md\"",
+			expected: `- [ ] Secret`,
+		},
+	})
+
+	strictPathsFlag = true
+	defer func() { strictPathsFlag = false }()
+
+	runTestQueries(t, []TestQuery{{
+		name:  "Symlinks escaping the vault are skipped in strict mode",
+		query: "TASK FROM \"notes/\"",
+		expected: `- [ ] January
+- [ ] Archived
+- [ ] Today`,
+	}})
+
+	for _, query := range []string{
+		"TASK FROM \"../" + filepath.Base(outside) + "/secret.md\"",
+		"TASK FROM \"notes/secret.md\"",
+		"TASK FROM \"notes/../../**/*.md\"",
+	} {
+		if _, err := executeQuery(query, false); err == nil {
+			t.Errorf("Expected an error in strict mode for %s", query)
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	userDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", userDir)
+	writeFiles(t, userDir, map[string]string{
+		"dynomark/config.toml": "date_format = \"DD.MM.YYYY\"\n\n[queries]\nall = 'LIST'\n",
+	})
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".dynomark.toml": `# Shared settings of the vault
+from = ["notes/"]
+ignore = [
+  "templates/",  # Not real notes
+]
+date_format = "YYYY/MM/DD"
+extensions = [".md", ".txt"]
+
+[tasks]
+states = [" ", "x", "-", ">"]
+done = ["x", "-"]
+
+[queries]
+open-tasks = 'TASK WHERE NOT CHECKED'
+"done tasks" = "TASK FROM \"notes/\" WHERE CHECKED"
+`,
+		"notes/tasks.md":         "- [ ] Open\n- [-] Cancelled\n- [>] Forwarded\n- [x] Done\n- [?] Not a task\n",
+		"notes/templates/day.md": "- [ ] Template\n",
+		"other/tasks.md":         "- [ ] Elsewhere\n",
+	})
+
+	vaultRoot = dir
+	defer func() {
+		vaultRoot = ""
+		config = defaultConfig()
+	}()
+	if err := loadConfig(); err != nil {
+		t.Fatalf("Error loading the configuration: %v", err)
+	}
+
+	if extensions := strings.Join(markdownExtensions(), ", "); extensions != ".md, .txt" {
+		t.Errorf("Expected the configured extensions, got %s", extensions)
+	}
+	if names := strings.Join(config.queryNames(), ", "); names != "all, done tasks, open-tasks" {
+		t.Errorf("Expected the saved queries of both configurations, got %s", names)
+	}
+
+	fileInfo, err := os.Stat(filepath.Join(dir, "notes", "tasks.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runTestQueries(t, []TestQuery{
+		{
+			name:  "Saved query using the default sources and task states",
+			query: config.Queries["open-tasks"],
+			expected: `- [ ] Open
+- [>] Forwarded`,
+		},
+		{
+			name:  "Saved query with custom done states",
+			query: config.Queries["done tasks"],
+			expected: `- [-] Cancelled
+- [x] Done`,
+		},
+		{
+			name:     "Configured date format",
+			query:    "LIST FROM \"notes/\" WHERE [file.mday] IS \"" + fileInfo.ModTime().Format("2006/01/02") + "\"",
+			expected: `- tasks.md`,
+		},
+		{
+			name:     "FROM overrides the default sources",
+			query:    "TASK FROM \"other/\"",
+			expected: `- [ ] Elsewhere`,
+		},
+	})
+
+	writeFiles(t, dir, map[string]string{".dynomark.toml": "[tasks]\nstatus = [\"x\"]\n"})
+	if err := loadConfig(); err == nil || !strings.Contains(err.Error(), "unknown setting tasks.status") {
+		t.Errorf("Expected an error for an unknown setting, got %v", err)
+	}
+
+	config = defaultConfig()
+	if _, err := executeQuery("TASK WHERE NOT CHECKED", false); err == nil {
+		t.Errorf("Expected an error for a query without FROM and default sources")
+	}
+}
+
+func TestVariables(t *testing.T) {
+	queryVariables = map[string]string{
+		"dir":    "examples/hugo/",
+		"author": "Jane Doe",
+		"n":      "1",
+		"evil":   `x" OR [title] CONTAINS "post`,
+	}
+	t.Setenv("DYNOMARK_VAR_DRAFT_STATE", "true")
+	contextFileFlag = "examples/hugo/second-post.md"
+	defer func() {
+		queryVariables = make(map[string]string)
+		contextFileFlag = ""
+	}()
+
+	runTestQueries(t, []TestQuery{
+		{
+			name:     "Variables in FROM and WHERE",
+			query:    "LIST FROM $dir WHERE [params.author] IS $author",
+			expected: `- first-post.md`,
+		},
+		{
+			name:     "Variable from the environment",
+			query:    "LIST FROM $dir WHERE [draft] IS $draft-state",
+			expected: `- second-post.md`,
+		},
+		{
+			name:     "Variable in LIMIT",
+			query:    "LIST FROM $dir LIMIT $n",
+			expected: `- first-post.md`,
+		},
+		{
+			name:     "Values are never parsed as part of the query",
+			query:    "LIST FROM $dir WHERE [title] IS $evil",
+			expected: ``,
+		},
+		{
+			name:     "Built-in variable for the context file",
+			query:    "HEADING FROM $this.file",
+			expected: `# Second post`,
+		},
+		{
+			name:     "Quoted strings aren't substituted",
+			query:    "LIST FROM $this.folder WHERE [title] IS \"$author\"",
+			expected: ``,
+		},
+	})
+
+	if _, err := executeQuery("LIST FROM $missing", false); err == nil || !strings.Contains(err.Error(), "unbound variable $missing") {
+		t.Errorf("Expected an error for an unbound variable, got %v", err)
+	}
+
+	if err := parseVariableFlag("me=alice=bob"); err != nil || queryVariables["me"] != "alice=bob" {
+		t.Errorf("Expected me to be bound to alice=bob, got %q (%v)", queryVariables["me"], err)
+	}
+	if err := parseVariableFlag("1st=value"); err == nil {
+		t.Errorf("Expected an error for an invalid variable name")
+	}
+}
+
+func TestContextFile(t *testing.T) {
+	contextFileFlag = "examples/projects/website.md"
+	defer func() { contextFileFlag = "" }()
+
+	runTestQueries(t, []TestQuery{
+		{
+			name:  "WHERE query on a field of the context file",
+			query: "LIST FROM \"examples/projects/\" WHERE [project] IS this.project",
+			expected: `- website-launch.md
+- website.md`,
+		},
+		{
+			name:  "TASK query with a $this variable",
+			query: "TASK FROM \"examples/projects/\" WHERE [project] IS $this.project AND NOT CHECKED",
+			expected: `- [ ] Write the announcement
+- [ ] Pick a domain`,
+		},
+		{
+			name:     "FROM the context file",
+			query:    "TASK FROM [[#]]",
+			expected: `- [ ] Pick a domain`,
+		},
+		{
+			name:     "File fields of the context file",
+			query:    "LIST FROM \"examples/projects/\" WHERE [file.name] IS this.file.name",
+			expected: `- website.md`,
+		},
+	})
+
+	if _, err := executeQuery("LIST FROM \"examples/projects/\" WHERE [status] IS this.status", false); err == nil || !strings.Contains(err.Error(), "has no status field") {
+		t.Errorf("Expected an error for a missing field of the context file, got %v", err)
+	}
+}
+
+func TestRepl(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"notes/today.md": "status:: draft\npriority:: high\n\n- [ ] First\n",
+	})
+
+	vaultRoot = dir
+	fileCache = make(map[string]*cachedFile)
+	defer func() {
+		vaultRoot = ""
+		fileCache = nil
+	}()
+
+	var out strings.Builder
+	r := &repl{out: &out}
+	r.loadVault()
+
+	if _, ok := fileCache[filepath.Join(dir, "notes", "today.md")]; !ok {
+		t.Errorf("Expected the vault to be cached, got %v", fileCache)
+	}
+
+	completions := []struct {
+		line       string
+		candidates string
+		start      int
+	}{
+		{"ta", "TASK, TABLE", 0},
+		{"TASK FROM \"notes/\" wh", "WHERE", 19},
+		{"LIST WHERE [pr", "[priority]", 11},
+		{"LIST WHERE [file.sh", "[file.shortname]", 11},
+		{"LIST ", "", 5},
+	}
+	for _, test := range completions {
+		candidates, start := r.complete(test.line)
+		if strings.Join(candidates, ", ") != test.candidates || start != test.start {
+			t.Errorf("Completing %q: expected %q at %d, got %q at %d", test.line, test.candidates, test.start, strings.Join(candidates, ", "), start)
+		}
+	}
+
+	input := `TASK FROM "notes/"
+LIST FROM "notes/" WHERE [status] IS "draft"
+TASK FROM
+.quit
+TASK FROM "notes/"
+`
+	if err := r.run(bufio.NewScanner(strings.NewReader(input))); err != nil {
+		t.Fatal(err)
+	}
+	expected := `- [ ] First
+- today.md
+Error: failed to parse query: expected a path after FROM, got the end of the query
+`
+	if out.String() != expected {
+		t.Errorf("Expected REPL output:\n%s\nGot:\n%s", expected, out.String())
+	}
+
+	// Modified files are read again
+	writeFiles(t, dir, map[string]string{"notes/today.md": "- [x] Changed\n"})
+	os.Chtimes(filepath.Join(dir, "notes", "today.md"), time.Now(), time.Now().Add(time.Hour))
+	out.Reset()
+	r.execute(`TASK FROM "notes/"`)
+	if out.String() != "- [x] Changed\n" {
+		t.Errorf("Expected the modified file to be read again, got:\n%s", out.String())
+	}
+
+	historyPath := filepath.Join(dir, "state", "history")
+	r.historyPath = historyPath
+	for _, line := range []string{"LIST FROM \"notes/\"", "TASK FROM \"notes/\"", "TASK FROM \"notes/\""} {
+		r.addHistory(line)
+	}
+	if history := loadHistory(historyPath); strings.Join(history, "|") != "LIST FROM \"notes/\"|TASK FROM \"notes/\"" {
+		t.Errorf("Expected the history to be saved without repeated queries, got %q", history)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	runTestQueries(t, []TestQuery{
+		{
+			name:  "EXPLAIN with file and item filters",
+			query: "EXPLAIN TASK FROM \"examples/projects/\" \"examples/missing/\" EXCLUDE \"api-design.md\" WHERE [project] IS \"website\" AND NOT CHECKED OR [item.line] > 3 SORT DESC LIMIT 2",
+			expected: `Query type: TASK (task list items)
+Sources:
+  "examples/projects/" (directory, walked recursively): 2 files
+  "examples/missing/" (missing): error: stat examples/missing/: no such file or directory
+Excluded: "api-design.md"
+Directories: only .md, .markdown, .mdx, .qmd files, skipping the ones ignored by .gitignore and .dynomarkignore
+Files (2):
+  examples/projects/website-launch.md
+  examples/projects/website.md
+Filters:
+  [project] IS "website" (checked for every item, same result for all the items of a file)
+  AND NOT CHECKED (checked for every item)
+  OR [item.line] > 3 (checked for every item)
+  WHERE conditions are evaluated left to right for every result
+Sort: result text in natural order, DESC
+Group: none
+Limit: first 2 results after filtering and sorting
+Estimated cost: 2 files, 194 bytes; every file is parsed into a block tree`,
+		},
+		{
+			name:  "EXPLAIN a TABLE query with ignored clauses",
+			query: "EXPLAIN TABLE title FROM \"examples/hugo/\" IN SECTION \"Intro\" SORT [draft] DESC, [title] ASC LIMIT 1",
+			expected: `Query type: TABLE (one row per file with a File column)
+Sources:
+  "examples/hugo/" (directory, walked recursively): 3 files
+Directories: only .md, .markdown, .mdx, .qmd files, skipping the ones ignored by .gitignore and .dynomarkignore
+Files (3):
+  examples/hugo/first-post.md
+  examples/hugo/second-post.md
+  examples/hugo/third-post.md
+Filters:
+  IN SECTION "Intro" (checked for every file, files without the heading are skipped)
+Sort:
+  [draft] DESC (ignored, TABLE queries can only sort by their columns)
+  [title] ASC
+Group: none
+Limit: first 1 results after filtering and sorting
+Estimated cost: 3 files, 471 bytes; only the metadata of every file is read, and its headings for IN SECTION`,
+		},
+	})
+
+	ast, err := Parse(Lex("EXPLAIN HEADING FROM \"examples/\" WHERE [level] <= 2 LIMIT 5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonData, err := json.Marshal(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Explain":true,"Type":"HEADING","From":["examples/"],"Where":{"Conditions":[{"IsNegated":false,"IsMetadata":true,"Field":"level","Function":"\u003c=","Value":"2"}]},"Limit":5}`
+	if string(jsonData) != expected {
+		t.Errorf("Expected AST:\n%s\nGot:\n%s", expected, jsonData)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	queries := []struct {
+		query    string
+		expected string
+	}{
+		{`task from notes/ where [a] is "b" and not checked limit 3`, `TASK FROM "notes/" WHERE [a] IS "b" AND NOT CHECKED LIMIT 3`},
+		{`list from "a/", "b/" exclude "*.draft.md" sort desc group by 2 [status]`, `LIST FROM "a/", "b/" EXCLUDE "*.draft.md" SORT DESC GROUP BY 2 [status]`},
+		{`TABLE_NO_ID title AS "Title", author FROM "books/" SORT [title]`, `TABLE NO ID title AS "Title", author FROM "books/" SORT [title] ASC`},
+		{"heading from [[#]] where [level] <= 2 and [project] is this.project or [owner] is $owner", `HEADING FROM [[#]] WHERE [level] <= 2 AND [project] IS this.project OR [owner] IS $owner`},
+		{`explain section direct "Notes" from $dir in section "Log"`, `EXPLAIN SECTION DIRECT "Notes" FROM $dir IN SECTION "Log"`},
+		{`task limit 3 where checked from "a/" where [p] is "x" or [q] is "y" sort desc limit 10 sort asc`, `TASK FROM "a/" WHERE CHECKED WHERE [p] IS "x" OR [q] IS "y" LIMIT 3 SORT DESC LIMIT 10 SORT ASC`},
+		{`list limit 2 group by [a] sort desc from "a/"`, `LIST FROM "a/" LIMIT 2 SORT DESC GROUP BY [a]`},
+		{`list offset 5 limit 10 sort asc group by [a] offset 1 from "a/"`, `LIST FROM "a/" LIMIT 10 OFFSET 5 SORT ASC GROUP BY [a] OFFSET 1`},
+		{`task sort desc distinct [project] from "a/"`, `TASK FROM "a/" DISTINCT [project] SORT DESC`},
+	}
+	for _, test := range queries {
+		formatted, err := formatQuery(test.query)
+		if err != nil {
+			t.Errorf("Error formatting %q: %v", test.query, err)
+			continue
+		}
+		if formatted != test.expected {
+			t.Errorf("Formatting %q:\nExpected: %s\nGot:      %s", test.query, test.expected, formatted)
+		}
+		// Formatting is idempotent
+		if again, _ := formatQuery(formatted); again != formatted {
+			t.Errorf("Formatting %q twice gave %s", test.query, again)
+		}
+	}
+
+	// Queries that don't parse aren't formatted
+	for _, query := range []string{`TASK FROM "notes/" LIMIT`, `TASK FROM "notes/" WHERE [a] IS`} {
+		if formatted, err := formatQuery(query); err == nil {
+			t.Errorf("Expected an error formatting %q, got %s", query, formatted)
+		}
+	}
+
+	fence := "```"
+	lines := strings.Split("---\ntitle: Queries\n---\n# Queries\n\n"+
+		fence+"dynomark\ntask from \"notes/\"\n  where not checked\n"+fence+"\n\n"+
+		"> "+fence+"dynomark\n> list from notes/ limit 2\n> "+fence+"\n\n"+
+		fence+"dynomark\nTASK FROM \"x\" WHERE [a] IS\n"+fence, "\n")
+	expected := "---\ntitle: Queries\n---\n# Queries\n\n" +
+		fence + "dynomark\nTASK FROM \"notes/\" WHERE NOT CHECKED\n" + fence + "\n\n" +
+		"> " + fence + "dynomark\n> LIST FROM \"notes/\" LIMIT 2\n> " + fence + "\n\n" +
+		fence + "dynomark\nTASK FROM \"x\" WHERE [a] IS\n" + fence
+
+	formatted, errs := formatQueryBlocks(lines)
+	if result := strings.Join(formatted, "\n"); result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+	if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "line 16:") {
+		t.Errorf("Expected an error on line 16, got %v", errs)
+	}
+}
+
+func TestLint(t *testing.T) {
+	knownKeys := map[string]bool{"project": true, "owner": true}
+	queries := []struct {
+		query    string
+		expected []string
+	}{
+		{`TASK FROM "examples/projects/" WHERE [project] IS "website" AND NOT CHECKED`, nil},
+		{`TABLE_NO_ID owner FROM "examples/projects/"`, []string{"TABLE_NO_ID is deprecated, use TABLE NO ID"}},
+		{`LIST FROM "examples/projects/" SORT [owner] DESC`, []string{"SORT by [owner] only works in TABLE and MDTABLE queries, LIST results are sorted by their text"}},
+		{`TABLE owner FROM "examples/projects/" SORT [project] ASC GROUP BY [owner] LIMIT 2`, []string{
+			"GROUP BY has no effect on TABLE queries",
+			"SORT by [project] has no effect, TABLE queries can only sort by their columns",
+		}},
+		{`LIST FROM "examples/projects/" WHERE [owner] IS "alice" OR [owner] IS "alice" AND CHECKED`, []string{
+			`the condition [owner] IS "alice" is repeated`,
+			"CHECKED only matches tasks, it never matches in LIST queries",
+		}},
+		{`HEADING FROM "examples/projects/" WHERE [level] <= 1 AND [stauts] IS "open"`, []string{"unknown metadata key [stauts], no file in the vault has it"}},
+		{`TASK FROM "examples/projects/" EXCLUDE "*.txt", "api-design.md"`, []string{`EXCLUDE "*.txt" doesn't exclude any file`}},
+		{`LIST SORT DESC SORT [owner] ASC FROM "examples/projects/" WHERE [owner] IS "alice"`, []string{
+			"SORT by [owner] only works in TABLE and MDTABLE queries, LIST results are sorted by their text",
+			"SORT DESC has no effect, it's followed by another SORT without a LIMIT or OFFSET in between",
+		}},
+		{`EXPLAIN TABLE owner FROM "examples/projects/" SORT [owner] ASC`, nil},
+		{`EXPLAIN LIST FROM "examples/projects/" SORT [owner] DESC`, []string{"SORT by [owner] only works in TABLE and MDTABLE queries, LIST results are sorted by their text"}},
+	}
+	for _, test := range queries {
+		messages := lintQuery(test.query, knownKeys)
+		if strings.Join(messages, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("Linting %q:\nExpected: %q\nGot:      %q", test.query, test.expected, messages)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	queries := []struct {
+		query    string
+		expected string
+	}{
+		{`TASK FROM "notes/" WHERE CONTIANS "x"`, "error parsing WHERE clause: expected a condition, got CONTIANS, did you mean CONTAINS?"},
+		{`TASK FROM notes/ LIMTI 5`, "unknown clause LIMTI after FROM, did you mean LIMIT? Quote it if it's a path"},
+		{`TASK FROM "notes/" LIMIT 5 junk`, "expected a clause or the end of the query after 5, got junk"},
+		{`TASKS FROM "notes/"`, "expected a query type, got TASKS, did you mean TASK?"},
+		{`LIMIT 5`, "expected a query type, got LIMIT"},
+		{`TASK WHER CHECKED`, "expected a clause or the end of the query after TASK, got WHER, did you mean WHERE?"},
+		{`TABLE title FORM "books/"`, "expected a comma or the next clause after the columns, got FORM, did you mean FROM?"},
+		{`LIST FROM "notes/" WHERE [status] IS open`, `error parsing WHERE clause: expected a value after IS, got open, quote text values ("open")`},
+		{`LIST FROM "notes/" WHERE [status] = "open"`, "error parsing WHERE clause: expected one of IS, CONTAINS, <, <=, >, >= after [status], got =, did you mean IS?"},
+		{`LIST FROM "notes/" WHERE CHECKED ANDD NOT CHECKED`, "error parsing WHERE clause: expected AND, OR or the next clause after CHECKED, got ANDD, did you mean AND?"},
+		{`LIST FROM "notes/" WHERE NOT`, "error parsing WHERE clause: expected a condition, got the end of the query"},
+		{`TABLE title FROM "books/" SORT [title] DSEC`, "error parsing SORT clause: expected ASC, DESC, a comma or the next clause, got DSEC, did you mean DESC?"},
+		{`LIST FROM "notes/" SORT [title]`, "error parsing SORT clause: metadata field not allowed in non-TABLE queries"},
+		{`LIST FROM "notes/" GROUP [status]`, "expected BY after GROUP, got [status]"},
+		{`LIST FROM "notes/" LIMIT`, "expected a number after LIMIT, got the end of the query"},
+		{`LIST FROM "notes/" OFFSET`, "expected a number after OFFSET, got the end of the query"},
+		{`LIST FROM "notes/" OFFSET -1`, "OFFSET can't be negative, got -1"},
+		{`LIST FROM`, "expected a path after FROM, got the end of the query"},
+		{`LIST FROM "notes/" IN SECTION "A" IN SECTION "B"`, "IN SECTION can only be given once"},
+		{`LIST FROM "notes/" GROUP BY [a] GROUP BY [b]`, "GROUP BY can only be given once"},
+		{`LIST FROM "notes/" DISTINCT DISTINCT [a]`, "DISTINCT can only be given once"},
+	}
+	for _, test := range queries {
+		_, err := Parse(Lex(test.query))
+		if err == nil {
+			t.Errorf("Expected an error parsing %q", test.query)
+			continue
+		}
+		if err.Error() != test.expected {
+			t.Errorf("Parsing %q:\nExpected: %s\nGot:      %s", test.query, test.expected, err)
+		}
+	}
+
+	// Quoted keywords are values, and paths near keywords can be quoted
+	for _, query := range []string{`LIST FROM "LIMTI" WHERE [status] IS "FROM"`, `TABLE title, author AS "By" FROM books/, "-books/drafts/" SORT [title] DESC, [author]`} {
+		if _, err := Parse(Lex(query)); err != nil {
+			t.Errorf("Error parsing %q: %v", query, err)
+		}
+	}
+}
+
+func TestClauseOrder(t *testing.T) {
+	runTestQueries(t, []TestQuery{
+		{
+			name:  "Clauses in any order",
+			query: "TASK WHERE NOT CHECKED FROM \"examples/projects/\"",
+			expected: `- [ ] Version the endpoints
+- [ ] Write the announcement
+- [ ] Pick a domain`,
+		},
+		{
+			name:     "WHERE clauses are ANDed",
+			query:    "TASK FROM \"examples/projects/\" WHERE [project] IS \"website\" WHERE CHECKED OR [project] IS \"api\"",
+			expected: `- [x] Set up analytics`,
+		},
+		{
+			name:  "LIMIT before SORT",
+			query: "TASK FROM \"examples/projects/\" LIMIT 2 SORT ASC",
+			expected: `- [ ] Write the announcement
+- [ ] Version the endpoints`,
+		},
+		{
+			name:  "Chained SORT and LIMIT stages",
+			query: "TASK FROM \"examples/projects/\" SORT ASC LIMIT 3 SORT DESC LIMIT 2",
+			expected: `- [ ] Version the endpoints
+- [ ] Write the announcement`,
+		},
+		{
+			name:  "The last LIMIT applies to every group",
+			query: "TASK SORT ASC LIMIT 3 GROUP BY [project] LIMIT 1 FROM \"examples/projects/\"",
+			expected: `- api
+    - [ ] Version the endpoints
+
+- website
+    - [x] Set up analytics
+
+`,
+		},
+		{
+			name:  "TABLE sorts are applied one after the other",
+			query: "TABLE owner, project FROM \"examples/projects/\" SORT [project] DESC SORT [owner] DESC",
+			expected: `| File              | owner | project |
+|-------------------|-------|---------|
+| website.md        | alice | website |
+| website-launch.md |       | website |
+| api-design.md     |       | api     |
+`,
+		},
+	})
+
+	ast, err := Parse(Lex("TASK LIMIT 2 WHERE CHECKED SORT DESC FROM \"notes/\" WHERE [a] IS \"b\""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonData, err := json.Marshal(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Type":"TASK","From":["notes/"],"Where":{"Conditions":[{"IsNegated":false,"IsMetadata":false,"Function":"CHECKED"}],"And":[{"Conditions":[{"IsNegated":false,"IsMetadata":true,"Field":"a","Function":"IS","Value":"b"}]}]},"Limit":2,"Stages":[{"Sorts":[{"Metadata":"","SortDirection":"DESC"}],"Limit":-1}]}`
+	if string(jsonData) != expected {
+		t.Errorf("Expected AST:\n%s\nGot:\n%s", expected, jsonData)
+	}
+}
+
+func TestOffset(t *testing.T) {
+	runTestQueries(t, []TestQuery{
+		{
+			name:  "OFFSET is skipped before the LIMIT",
+			query: "TASK FROM \"examples/projects/\" LIMIT 2 OFFSET 1",
+			expected: `- [ ] Write the announcement
+- [x] Set up analytics`,
+		},
+		{
+			name:     "OFFSET without LIMIT",
+			query:    "TASK FROM \"examples/projects/\" OFFSET 3",
+			expected: `- [ ] Pick a domain`,
+		},
+		{
+			name:     "OFFSET past the results",
+			query:    "TASK FROM \"examples/projects/\" OFFSET 10",
+			expected: ``,
+		},
+		{
+			name:  "The last OFFSET applies to every group",
+			query: "TASK FROM \"examples/projects/\" GROUP BY [project] OFFSET 1 LIMIT 1",
+			expected: `- website
+    - [x] Set up analytics
+
+`,
+		},
+		{
+			name:  "TABLE rows",
+			query: "TABLE project FROM \"examples/projects/\" SORT [project] ASC LIMIT 1 OFFSET 1",
+			expected: `| File              | project |
+|-------------------|---------|
+| website-launch.md | website |
+`,
+		},
+	})
+}
+
+func TestPage(t *testing.T) {
+	defer func(page, size int) { pageFlag, pageSizeFlag = page, size }(pageFlag, pageSizeFlag)
+
+	tests := []struct {
+		page     int
+		size     int
+		query    string
+		expected string
+	}{
+		{2, 3, `TASK FROM "examples/projects/"`, `{"page":2,"page_size":3,"total":4,"total_pages":2,"results":["- [ ] Pick a domain"]}`},
+		{3, 3, `TASK FROM "examples/projects/"`, `{"page":3,"page_size":3,"total":4,"total_pages":2,"results":[]}`},
+		{1, 1, `TASK FROM "examples/projects/" WHERE NOT CHECKED GROUP BY [project]`, `{"page":1,"page_size":1,"total":2,"total_pages":2,"results":[{"group":"api","results":["- [ ] Version the endpoints"]}]}`},
+		{1, 10, `TABLE project FROM "examples/projects/" SORT [project] DESC OFFSET 1`, `{"page":1,"page_size":10,"total":2,"total_pages":1,"columns":["File","project"],"results":[["website.md","website"],["api-design.md","api"]]}`},
+		{1, 1, `MDTABLE FROM "examples/logs/reading-2024.md" WHERE [Status] IS "reading"`, `{"page":1,"page_size":1,"total":1,"total_pages":1,"columns":["Book","Author","Pages","Status"],"results":[["Crafting Interpreters","Robert Nystrom","640","reading"]]}`},
+	}
+	for _, test := range tests {
+		pageFlag, pageSizeFlag = test.page, test.size
+		result, err := executeQuery(test.query, false)
+		if err != nil {
+			t.Errorf("Error executing %q: %v", test.query, err)
+			continue
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, []byte(result)); err != nil {
+			t.Errorf("Page of %q isn't JSON: %v\n%s", test.query, err, result)
+			continue
+		}
+		if compact.String() != test.expected {
+			t.Errorf("Page %d of %q:\nExpected: %s\nGot:      %s", test.page, test.query, test.expected, compact.String())
+		}
+	}
+
+	pageFlag, pageSizeFlag = 1, 0
+	if _, err := executeQuery(`TASK FROM "examples/projects/"`, false); err == nil {
+		t.Error("Expected an error for a page size of 0")
+	}
+}
+
+func TestDistinct(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.md":       "---\nproject: website\n---\n- [ ] Review\n- [ ] Deploy",
+		"b.md":       "---\nproject: website\n---\n- [ ] Review",
+		"c.md":       "- [ ] Review\n- [ ] Plan",
+		"sub/d.md":   "- [ ] Archive",
+		"other/e.md": "---\nproject: api\n---\n- [ ] Review",
+	})
+	if err := os.Symlink(filepath.Join(dir, "sub"), filepath.Join(dir, "linked")); err != nil {
+		t.Skipf("can't create symlinks: %v", err)
+	}
+
+	runTestQueries(t, []TestQuery{
+		{
+			name:  "Duplicates without DISTINCT",
+			query: fmt.Sprintf("TASK FROM \"%s/a.md\", \"%s/b.md\"", dir, dir),
+			expected: `- [ ] Review
+- [ ] Deploy
+- [ ] Review`,
+		},
+		{
+			name:  "DISTINCT on the item text keeps the first one",
+			query: fmt.Sprintf("TASK FROM \"%s/a.md\", \"%s/b.md\", \"%s/c.md\" DISTINCT", dir, dir, dir),
+			expected: `- [ ] Review
+- [ ] Deploy
+- [ ] Plan`,
+		},
+		{
+			name:  "DISTINCT on a field",
+			query: fmt.Sprintf("TASK FROM \"%s/a.md\", \"%s/c.md\", \"%s/other/\" DISTINCT [project]", dir, dir, dir),
+			expected: `- [ ] Review
+- [ ] Review
+- [ ] Review`,
+		},
+		{
+			name:     "A file listed twice is parsed once",
+			query:    fmt.Sprintf("TASK FROM \"%s/sub/\", \"%s/sub/d.md\", \"%s/linked/\", \"%s/./sub/d.md\"", dir, dir, dir, dir),
+			expected: `- [ ] Archive`,
+		},
+		{
+			name:  "DISTINCT TABLE rows",
+			query: fmt.Sprintf("TABLE NO ID project FROM \"%s/a.md\", \"%s/b.md\", \"%s/other/\" DISTINCT", dir, dir, dir),
+			expected: `| project |
+|---------|
+| website |
+| api     |
+`,
+		},
+	})
+}
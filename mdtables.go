@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseMarkdownTables returns every row of every GFM pipe table in the
+// document. The cells of a row are stored as fields named after the column
+// headers, so rows can be filtered by column (e.g. WHERE [Status] IS "done").
+func parseMarkdownTables(doc *Document) []ContentItem {
+	var rows []ContentItem
+	tableIndex := 0
+
+	walkBlocks(doc.Root, func(block *Block) bool {
+		if block.Type != BLOCK_TABLE {
+			return true
+		}
+
+		header := splitTableRow(block.Content[0])
+		alignments, _ := parseTableDelimiterRow(block.Content[1])
+		tableIndex++
+
+		for rowIndex, line := range block.Content[2:] {
+			cells := splitTableRow(line)
+			fields := Metadata{
+				"table.header": header,
+				"table.align":  alignments,
+				"table.index":  tableIndex,
+				"table.row":    rowIndex + 1,
+			}
+			for col, name := range header {
+				value := ""
+				if col < len(cells) {
+					value = cells[col]
+				}
+				fields[name] = value
+				if lowerName := strings.ToLower(name); lowerName != name {
+					if _, exists := fields[lowerName]; !exists {
+						fields[lowerName] = value
+					}
+				}
+			}
+
+			rows = append(rows, ContentItem{Text: strings.TrimSpace(line), Line: block.StartLine + 2 + rowIndex, Fields: fields})
+		}
+		return true
+	})
+
+	return rows
+}
+
+// splitTableRow splits a table row into trimmed cells, ignoring the optional
+// leading and trailing pipes and pipes that are escaped or inside code spans
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, "\\|") {
+		line = line[:len(line)-1]
+	}
+
+	var cells []string
+	var cell strings.Builder
+	inCode := false
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '`':
+			inCode = !inCode
+			cell.WriteByte('`')
+		case line[i] == '|' && !inCode:
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	cells = append(cells, strings.TrimSpace(cell.String()))
+
+	return cells
+}
+
+// parseTableDelimiterRow parses the row under the table header (e.g.
+// "|:---|:---:|---:|") and returns the alignment of every column
+func parseTableDelimiterRow(line string) ([]string, bool) {
+	if !strings.Contains(line, "-") {
+		return nil, false
+	}
+
+	var alignments []string
+	for _, cell := range splitTableRow(line) {
+		dashes := strings.TrimSuffix(strings.TrimPrefix(cell, ":"), ":")
+		if dashes == "" || strings.Trim(dashes, "-") != "" {
+			return nil, false
+		}
+
+		left := strings.HasPrefix(cell, ":")
+		right := strings.HasSuffix(cell, ":")
+		switch {
+		case left && right:
+			alignments = append(alignments, "center")
+		case right:
+			alignments = append(alignments, "right")
+		case left:
+			alignments = append(alignments, "left")
+		default:
+			alignments = append(alignments, "")
+		}
+	}
+
+	return alignments, true
+}
+
+// renderMarkdownTable merges the given MDTABLE rows into one table. The
+// columns are the union of the headers of all the source tables, in the order
+// they were first seen.
+func renderMarkdownTable(metadataList []Metadata) string {
+	headers, alignments := tableColumns(metadataList)
+	if len(headers) == 0 {
+		return ""
+	}
+
+	maxWidths := make([]int, len(headers))
+	for i, header := range headers {
+		// The delimiter row needs at least 3 characters
+		maxWidths[i] = max(utf8.RuneCountInString(header), 3)
+	}
+
+	rows := make([][]string, len(metadataList))
+	for r, metadata := range metadataList {
+		rows[r] = tableCells(metadata, headers)
+		for i, cell := range rows[r] {
+			rows[r][i] = strings.ReplaceAll(cell, "|", "\\|")
+			maxWidths[i] = max(maxWidths[i], utf8.RuneCountInString(rows[r][i]))
+		}
+	}
+
+	var result strings.Builder
+	for i, header := range headers {
+		result.WriteString("| " + tablePadString(header, maxWidths[i]) + " ")
+	}
+	result.WriteString("|\n")
+
+	for i, width := range maxWidths {
+		switch alignments[i] {
+		case "left":
+			result.WriteString("|:" + strings.Repeat("-", width+1))
+		case "right":
+			result.WriteString("|" + strings.Repeat("-", width+1) + ":")
+		case "center":
+			result.WriteString("|:" + strings.Repeat("-", width) + ":")
+		default:
+			result.WriteString("|" + strings.Repeat("-", width+2))
+		}
+	}
+	result.WriteString("|\n")
+
+	for _, row := range rows {
+		for i, cell := range row {
+			result.WriteString("| " + tablePadString(cell, maxWidths[i]) + " ")
+		}
+		result.WriteString("|\n")
+	}
+
+	return result.String()
+}
+
+// tableColumns returns the union of the headers of MDTABLE rows, in the order
+// they were first seen, with their alignments
+func tableColumns(metadataList []Metadata) ([]string, []string) {
+	var headers []string
+	var alignments []string
+	seen := make(map[string]bool)
+
+	for _, metadata := range metadataList {
+		header, _ := metadata["table.header"].([]string)
+		align, _ := metadata["table.align"].([]string)
+		for i, name := range header {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			headers = append(headers, name)
+			alignments = append(alignments, align[i])
+		}
+	}
+
+	return headers, alignments
+}
+
+// tableCells returns the cells of an MDTABLE row in the given columns. The
+// columns its own table doesn't have are empty.
+func tableCells(metadata Metadata, columns []string) []string {
+	header, _ := metadata["table.header"].([]string)
+	cells := make([]string, len(columns))
+	for i, name := range columns {
+		if slices.Contains(header, name) {
+			cells[i] = fmt.Sprintf("%v", metadata[name])
+		}
+	}
+	return cells
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Extensions of the files picked up when walking directories, set with the
+// -ext flag as a comma separated list. Without the flag, the extensions of the
+// configuration are used, or defaultExtensions if it has none.
+var extensionsFlag string
+
+var defaultExtensions = []string{".md", ".markdown", ".mdx", ".qmd"}
+
+// markdownExtensions returns the normalized list of recognized extensions
+// (lowercase, with a leading dot)
+func markdownExtensions() []string {
+	values := defaultExtensions
+	if extensionsFlag != "" {
+		values = strings.Split(extensionsFlag, ",")
+	} else if config.Extensions != nil {
+		values = config.Extensions
+	}
+
+	var extensions []string
+	for _, ext := range values {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		extensions = append(extensions, ext)
+	}
+	return extensions
+}
+
+// isMarkdownFile checks if a file has one of the recognized extensions
+func isMarkdownFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, markdownExt := range markdownExtensions() {
+		if ext == markdownExt {
+			return true
+		}
+	}
+	return false
+}
+
+var jsxTagRegex = regexp.MustCompile(`^<(/?)([A-Za-z][\w.:-]*)?(\s|/|>|$)`)
+
+// stripMDXSyntax blanks out the lines of MDX files that aren't markdown:
+// import/export statements, JSX tags and {expressions}. The markdown between
+// an opening and a closing JSX tag is kept. Lines are blanked instead of
+// removed so line numbers stay correct.
+func stripMDXSyntax(lines []string) []string {
+	result := make([]string, len(lines))
+	copy(result, lines)
+
+	var fence *CodeFence
+	previousBlank := true
+
+	for i := 0; i < len(result); i++ {
+		trimmedLine := strings.TrimSpace(result[i])
+
+		// Code blocks are left alone
+		if fence != nil {
+			if strings.Trim(trimmedLine, string(fence.Char)) == "" && len(trimmedLine) >= fence.Length {
+				fence = nil
+			}
+			previousBlank = false
+			continue
+		}
+		if opening, ok := parseFenceOpening(result[i]); ok {
+			fence = &opening
+			previousBlank = false
+			continue
+		}
+
+		switch {
+		case previousBlank && (strings.HasPrefix(trimmedLine, "import ") || strings.HasPrefix(trimmedLine, "export ")):
+			// ESM statements run until the next blank line
+			for ; i < len(result) && strings.TrimSpace(result[i]) != ""; i++ {
+				result[i] = ""
+			}
+			i--
+
+		case isJSXLine(trimmedLine):
+			end := jsxEnd(result, i)
+			for ; i <= end; i++ {
+				result[i] = ""
+			}
+			i--
+
+		case strings.HasPrefix(trimmedLine, "{"):
+			end := jsxEnd(result, i)
+			for ; i <= end; i++ {
+				result[i] = ""
+			}
+			i--
+		}
+
+		previousBlank = strings.TrimSpace(result[i]) == ""
+	}
+
+	return result
+}
+
+// isJSXLine checks if a line starts with a JSX tag or fragment. Autolinks
+// like <https://example.com> aren't tags.
+func isJSXLine(line string) bool {
+	match := jsxTagRegex.FindStringSubmatch(line)
+	if match == nil {
+		return false
+	}
+	if match[2] == "" {
+		// Only fragments (<> and </>) have no name
+		return match[3] == ">"
+	}
+	return !strings.Contains(match[2], ":")
+}
+
+// jsxEnd returns the index of the line where the tag or expression starting
+// on the given line ends, taking quotes and nested braces into account
+func jsxEnd(lines []string, start int) int {
+	expression := strings.HasPrefix(strings.TrimSpace(lines[start]), "{")
+	depth := 0
+	var quote byte
+
+	for i := start; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		for j := 0; j < len(line); j++ {
+			char := line[j]
+			switch {
+			case quote != 0:
+				if char == quote {
+					quote = 0
+				}
+			case char == '"' || char == '\'' || char == '`':
+				quote = char
+			case char == '{':
+				depth++
+			case char == '}':
+				depth--
+				if expression && depth == 0 {
+					return i
+				}
+			case char == '>' && depth == 0 && !expression:
+				return i
+			}
+		}
+	}
+
+	return len(lines) - 1
+}
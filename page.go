@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Flags of the paginated output. Pages start at 1, 0 turns pagination off.
+var pageFlag int
+var pageSizeFlag = 20
+
+// pageOutput is the JSON printed for a page of results. Total counts all the
+// results of the query (or its groups, with GROUP BY), not only the ones on
+// the page. Rows of TABLE and MDTABLE queries are arrays of cells in the
+// order of Columns.
+type pageOutput struct {
+	Page       int      `json:"page"`
+	PageSize   int      `json:"page_size"`
+	Total      int      `json:"total"`
+	TotalPages int      `json:"total_pages"`
+	Columns    []string `json:"columns,omitempty"`
+	Results    any      `json:"results"`
+}
+
+// pageGroup is a group of results in the page of a GROUP BY query
+type pageGroup struct {
+	Group   string `json:"group"`
+	Results []any  `json:"results"`
+}
+
+// checkPageFlags checks the values of -page and -page-size
+func checkPageFlags() error {
+	if pageFlag < 0 {
+		return fmt.Errorf("-page has to be 1 or more, got %d", pageFlag)
+	}
+	if pageSizeFlag < 1 {
+		return fmt.Errorf("-page-size has to be 1 or more, got %d", pageSizeFlag)
+	}
+	return nil
+}
+
+// renderPage writes the page selected with -page and -page-size as JSON.
+// Columns are only given for the rows of tables.
+func renderPage[T any](results []T, columns []string) (string, error) {
+	start := min((pageFlag-1)*pageSizeFlag, len(results))
+	end := min(start+pageSizeFlag, len(results))
+
+	output := pageOutput{
+		Page:       pageFlag,
+		PageSize:   pageSizeFlag,
+		Total:      len(results),
+		TotalPages: (len(results) + pageSizeFlag - 1) / pageSizeFlag,
+		Columns:    columns,
+		// Copied so an empty page is [] and not null
+		Results: append([]T{}, results[start:end]...),
+	}
+
+	jsonData, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(jsonData), nil
+}
+
+// pageItem returns a result as it's written in a page: the text of an item,
+// or the cells of a markdown table row in the given columns
+func pageItem(queryType QueryType, content string, metadata Metadata, columns []string) any {
+	if queryType != MDTABLE {
+		return content
+	}
+	return tableCells(metadata, columns)
+}
@@ -0,0 +1,632 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The grammar of a query. Every token has to be consumed, anything the
+// grammar doesn't expect at its position is an error.
+//
+//	query      = ["EXPLAIN"] type {clause}
+//	clause     = "FROM" paths | "EXCLUDE" paths | "IN" "SECTION" heading
+//	           | "WHERE" conditions | "DISTINCT" [field] | "SORT" sorts
+//	           | "GROUP" "BY" [number] field | "LIMIT" number | "OFFSET" number
+//	type       = "TABLE" ["NO" "ID"] [column {"," column}]
+//	           | "SECTION" ["DIRECT"] heading
+//	           | "LIST" | "TASK" | "PARAGRAPH" | ...
+//	column     = name ["AS" string]
+//	paths      = path {[","] path}
+//	conditions = condition {("AND" | "OR") condition}
+//	condition  = ["NOT"] ("CHECKED" | [field] function value)
+//	sorts      = [field] ["ASC" | "DESC"] {"," field ["ASC" | "DESC"]}
+//
+// Clauses can come in any order. FROM, EXCLUDE and WHERE can be repeated:
+// paths add up and WHERE clauses are ANDed. SORT, LIMIT and OFFSET clauses
+// form stages applied one after the other, so SORT DESC LIMIT 10 SORT ASC
+// sorts the last 10 results in ascending order. The OFFSET of a stage is
+// skipped before its LIMIT is taken, whichever is written first. Only TABLE
+// and MDTABLE queries sort by fields, the other queries sort by their text in
+// a single direction.
+
+// Keywords that start a clause
+var clauseKeywords = []string{"FROM", "EXCLUDE", "IN", "WHERE", "DISTINCT", "SORT", "GROUP", "LIMIT", "OFFSET"}
+
+var queryTypes = []QueryType{LIST, TASK, PARAGRAPH, ORDEREDLIST, UNORDEREDLIST, FENCEDCODE, HEADING, SECTION, BLOCKQUOTE, CALLOUT, LINK, IMAGE, MDTABLE, TABLE}
+
+var conditionFunctions = []string{"IS", "CONTAINS", "<", "<=", ">", ">="}
+
+// Common spellings of other query languages and what they're called here
+var keywordAliases = map[string]string{
+	"=":     "IS",
+	"==":    "IS",
+	"TASKS": "TASK",
+	"ORDER": "SORT",
+	"LIKE":  "CONTAINS",
+}
+
+type parser struct {
+	tokens []Token
+	pos    int
+	stages []StageNode // SORT, LIMIT and OFFSET stages, in the order they're written
+}
+
+func Parse(tokens []Token) (*QueryNode, error) {
+	if len(tokens) == 0 || tokens[len(tokens)-1].Type != TOKEN_EOF {
+		tokens = append(slices.Clone(tokens), Token{Type: TOKEN_EOF})
+	}
+	p := &parser{tokens: tokens}
+	return p.parseQuery()
+}
+
+func (p *parser) peek() Token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() Token {
+	token := p.tokens[p.pos]
+	if token.Type != TOKEN_EOF {
+		p.pos++
+	}
+	return token
+}
+
+// at checks if the next token is the given keyword. Strings and identifiers
+// never match, so a quoted "FROM" is always a value.
+func (p *parser) at(keyword string) bool {
+	token := p.peek()
+	switch token.Type {
+	case TOKEN_STRING, TOKEN_IDENTIFIER, TOKEN_NUMBER, TOKEN_METADATA, TOKEN_VARIABLE:
+		return false
+	}
+	return token.Value == keyword
+}
+
+// atWord checks if the next token is an identifier like ASC or DIRECT, which
+// are only keywords at some positions
+func (p *parser) atWord(word string) bool {
+	token := p.peek()
+	return token.Type == TOKEN_IDENTIFIER && strings.EqualFold(token.Value, word)
+}
+
+// atClause checks if the next token starts a clause or ends the query
+func (p *parser) atClause() bool {
+	if p.peek().Type == TOKEN_EOF {
+		return true
+	}
+	return slices.ContainsFunc(clauseKeywords, p.at)
+}
+
+// expected returns an error for the next token, which isn't what the grammar
+// expects. Candidates are the keywords that would be valid instead; if the
+// token looks like a misspelling of one of them, it's suggested.
+func (p *parser) expected(what string, candidates ...string) error {
+	token := p.peek()
+	message := fmt.Sprintf("expected %s, got %s", what, describeToken(token))
+	// Keywords are only misplaced, not misspelled
+	if token.Type == TOKEN_IDENTIFIER {
+		if suggestion := suggestKeyword(token.Value, candidates); suggestion != "" {
+			message += fmt.Sprintf(", did you mean %s?", suggestion)
+		}
+	}
+	return fmt.Errorf("%s", message)
+}
+
+func (p *parser) parseQuery() (*QueryNode, error) {
+	query := &QueryNode{Limit: -1}
+
+	if p.at("EXPLAIN") {
+		p.next()
+		query.Explain = true
+	}
+
+	if err := p.parseType(query); err != nil {
+		return nil, err
+	}
+
+	for p.peek().Type != TOKEN_EOF {
+		if err := p.parseClause(query); err != nil {
+			return nil, err
+		}
+	}
+
+	// The first stage is kept in the Sorts and Limit of the query
+	if len(p.stages) > 0 {
+		query.Sorts = p.stages[0].Sorts
+		query.Limit = p.stages[0].Limit
+		query.Offset = p.stages[0].Offset
+		query.Stages = p.stages[1:]
+	}
+	return query, nil
+}
+
+func (p *parser) parseClause(query *QueryNode) error {
+	switch {
+	case p.at("FROM"):
+		p.next()
+		return p.parseFrom(query)
+	case p.at("EXCLUDE"):
+		p.next()
+		return p.parseExclude(query)
+	case p.at("IN"):
+		if query.InSection != "" {
+			return fmt.Errorf("IN SECTION can only be given once")
+		}
+		p.next()
+		return p.parseInSection(query)
+	case p.at("WHERE"):
+		p.next()
+		return p.parseWhere(query)
+	case p.at("DISTINCT"):
+		if query.Distinct {
+			return fmt.Errorf("DISTINCT can only be given once")
+		}
+		p.next()
+		p.parseDistinct(query)
+		return nil
+	case p.at("SORT"):
+		p.next()
+		return p.parseSort(query)
+	case p.at("GROUP"):
+		if query.GroupBy != "" {
+			return fmt.Errorf("GROUP BY can only be given once")
+		}
+		p.next()
+		return p.parseGroupBy(query)
+	case p.at("LIMIT"):
+		p.next()
+		return p.parseLimit()
+	case p.at("OFFSET"):
+		p.next()
+		return p.parseOffset()
+	}
+
+	if p.pos == 0 {
+		return p.expected("a query type")
+	}
+	return p.expected("a clause or the end of the query after "+describeToken(p.tokens[p.pos-1]), clauseKeywords...)
+}
+
+// stage returns the stage a SORT, LIMIT or OFFSET clause belongs to. A stage
+// is a SORT followed by a LIMIT and an OFFSET in any order, anything after
+// that starts a new one.
+func (p *parser) stage(clause string) *StageNode {
+	if len(p.stages) > 0 {
+		last := &p.stages[len(p.stages)-1]
+		var fits bool
+		switch clause {
+		case "SORT":
+			fits = len(last.Sorts) == 0 && last.Limit < 0 && last.Offset == 0
+		case "LIMIT":
+			fits = last.Limit < 0
+		case "OFFSET":
+			fits = last.Offset == 0
+		}
+		if fits {
+			return last
+		}
+	}
+	p.stages = append(p.stages, StageNode{Limit: -1})
+	return &p.stages[len(p.stages)-1]
+}
+
+func (p *parser) parseType(query *QueryNode) error {
+	token := p.peek()
+	switch {
+	case token.Type == TOKEN_TABLE:
+		p.next()
+		query.Type = TABLE
+		if p.atWord("NO") {
+			p.next()
+			if !p.atWord("ID") {
+				return p.expected("ID after TABLE NO")
+			}
+			p.next()
+			query.Type = TABLE_NO_ID
+		}
+		return p.parseColumns(query)
+	case token.Type == TOKEN_TABLE_NO_ID:
+		// DEPRECATED: Handle the old TOKEN_TABLE_NO_ID for backward compatibility
+		p.next()
+		query.Type = TABLE_NO_ID
+		return p.parseColumns(query)
+	}
+
+	queryType, ok := parseQueryType(token.Value)
+	if token.Type != TOKEN_KEYWORD || !ok {
+		names := make([]string, len(queryTypes))
+		for i, queryType := range queryTypes {
+			names[i] = string(queryType)
+		}
+		return p.expected("a query type", names...)
+	}
+	p.next()
+	query.Type = queryType
+
+	if queryType == SECTION {
+		if p.atWord("DIRECT") {
+			p.next()
+			query.SectionDirect = true
+		}
+		heading, err := p.parseName("a section heading after SECTION")
+		if err != nil {
+			return err
+		}
+		query.Section = heading
+	}
+	return nil
+}
+
+// parseColumns parses the comma separated columns of a TABLE query
+func (p *parser) parseColumns(query *QueryNode) error {
+	if p.peek().Type != TOKEN_IDENTIFIER {
+		return nil
+	}
+	for {
+		if p.peek().Type != TOKEN_IDENTIFIER {
+			return p.expected("a column name")
+		}
+		column := ColumnDefinition{Name: p.next().Value}
+		column.Alias = column.Name
+		if p.at("AS") {
+			p.next()
+			if p.peek().Type != TOKEN_STRING {
+				return p.expected("a quoted column alias after AS")
+			}
+			column.Alias = p.next().Value
+		}
+		query.Columns = append(query.Columns, column)
+
+		if p.peek().Type != TOKEN_COMMA {
+			break
+		}
+		p.next()
+	}
+	if !p.atClause() {
+		// TABLE title FORM "books/" reads FORM as a column
+		last := query.Columns[len(query.Columns)-1]
+		if suggestion := suggestKeyword(last.Name, clauseKeywords); suggestion != "" && last.Alias == last.Name {
+			return fmt.Errorf("unknown clause %s after the columns, did you mean %s?", last.Name, suggestion)
+		}
+		return p.expected("a comma or the next clause after the columns", clauseKeywords...)
+	}
+	return nil
+}
+
+// parseName parses a heading or a path, which can be quoted or not
+func (p *parser) parseName(what string) (string, error) {
+	token := p.peek()
+	if token.Type != TOKEN_STRING && token.Type != TOKEN_IDENTIFIER && token.Type != TOKEN_NUMBER {
+		return "", p.expected(what)
+	}
+	p.next()
+	return token.Value, nil
+}
+
+// parsePaths parses the sources of FROM or the patterns of EXCLUDE
+func (p *parser) parsePaths(keyword string) ([]string, error) {
+	var paths []string
+	for {
+		token := p.peek()
+		if token.Type == TOKEN_IDENTIFIER && !isPathLike(token.Value) {
+			// An unquoted word that looks like a misspelled keyword is more
+			// likely a typo than a path
+			if suggestion := suggestKeyword(token.Value, clauseKeywords); suggestion != "" {
+				return nil, fmt.Errorf("unknown clause %s after %s, did you mean %s? Quote it if it's a path", token.Value, keyword, suggestion)
+			}
+		}
+		path, err := p.parseName("a path after " + describeToken(p.tokens[p.pos-1]))
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+
+		if p.peek().Type == TOKEN_COMMA {
+			p.next()
+			continue
+		}
+		if p.atClause() {
+			return paths, nil
+		}
+	}
+}
+
+// isPathLike checks if an unquoted word contains characters that only appear
+// in paths and patterns
+func isPathLike(word string) bool {
+	return strings.ContainsAny(word, "/\\.*?-_")
+}
+
+func (p *parser) parseFrom(query *QueryNode) error {
+	paths, err := p.parsePaths("FROM")
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		// A "-" prefix excludes the path instead (e.g. FROM "notes/" "-notes/archive/")
+		if strings.HasPrefix(path, "-") && len(path) > 1 {
+			query.Exclude = append(query.Exclude, path[1:])
+		} else {
+			query.From = append(query.From, path)
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseExclude(query *QueryNode) error {
+	paths, err := p.parsePaths("EXCLUDE")
+	if err != nil {
+		return err
+	}
+	query.Exclude = append(query.Exclude, paths...)
+	return nil
+}
+
+func (p *parser) parseInSection(query *QueryNode) error {
+	if !p.at("SECTION") {
+		return p.expected("SECTION after IN", "SECTION")
+	}
+	p.next()
+	heading, err := p.parseName("a section heading after IN SECTION")
+	if err != nil {
+		return err
+	}
+	query.InSection = heading
+	return nil
+}
+
+func (p *parser) parseWhere(query *QueryNode) error {
+	where, err := p.parseConditions()
+	if err != nil {
+		return fmt.Errorf("error parsing WHERE clause: %w", err)
+	}
+	// Further WHERE clauses are ANDed with the first one
+	if query.Where == nil {
+		query.Where = where
+	} else {
+		query.Where.And = append(query.Where.And, where)
+	}
+	return nil
+}
+
+// parseDistinct parses the optional field of a DISTINCT clause. Without one,
+// results are compared by their text.
+func (p *parser) parseDistinct(query *QueryNode) {
+	query.Distinct = true
+	if p.peek().Type == TOKEN_METADATA {
+		query.DistinctField = p.next().Value
+	}
+}
+
+// parseConditions parses conditions joined by AND and OR
+func (p *parser) parseConditions() (*WhereNode, error) {
+	where := &WhereNode{}
+	logicalOp := ""
+	for {
+		condition, err := p.parseCondition()
+		if err != nil {
+			return nil, err
+		}
+		condition.LogicalOp = logicalOp
+		where.Conditions = append(where.Conditions, condition)
+
+		if p.atClause() {
+			return where, nil
+		}
+		if p.peek().Type != TOKEN_LOGICAL_OP {
+			candidates := append([]string{"AND", "OR"}, clauseKeywords...)
+			return nil, p.expected("AND, OR or the next clause after "+formatCondition(condition), candidates...)
+		}
+		logicalOp = p.next().Value
+	}
+}
+
+func (p *parser) parseCondition() (ConditionNode, error) {
+	var condition ConditionNode
+	if p.peek().Type == TOKEN_NOT {
+		p.next()
+		condition.IsNegated = true
+	}
+
+	if p.at("CHECKED") {
+		p.next()
+		condition.Function = "CHECKED"
+		return condition, nil
+	}
+
+	if p.peek().Type == TOKEN_METADATA {
+		condition.IsMetadata = true
+		condition.Field = p.next().Value
+		if p.peek().Type != TOKEN_FUNCTION {
+			return condition, p.expected(fmt.Sprintf("one of %s after [%s]", strings.Join(conditionFunctions, ", "), condition.Field), conditionFunctions...)
+		}
+	} else if p.peek().Type != TOKEN_FUNCTION {
+		candidates := append([]string{"NOT", "CHECKED"}, conditionFunctions...)
+		return condition, p.expected("a condition", candidates...)
+	}
+	condition.Function = p.next().Value
+
+	value := p.peek()
+	switch value.Type {
+	case TOKEN_STRING, TOKEN_NUMBER:
+		p.next()
+		condition.Value = value.Value
+		return condition, nil
+	case TOKEN_IDENTIFIER:
+		return condition, fmt.Errorf("expected a value after %s, got %s, quote text values (\"%s\")", condition.Function, value.Value, value.Value)
+	}
+	return condition, p.expected("a value after " + condition.Function)
+}
+
+func (p *parser) parseSort(query *QueryNode) error {
+	sortNodes, err := p.parseSortKeys(query.Type)
+	if err != nil {
+		return fmt.Errorf("error parsing SORT clause: %w", err)
+	}
+	p.stage("SORT").Sorts = sortNodes
+	return nil
+}
+
+func (p *parser) parseSortKeys(queryType QueryType) ([]SortNode, error) {
+	byField := queryType == TABLE || queryType == TABLE_NO_ID || queryType == MDTABLE
+	var sortNodes []SortNode
+
+	for {
+		sortNode := SortNode{SortDirection: "ASC"}
+		if p.peek().Type == TOKEN_METADATA {
+			if !byField {
+				return nil, fmt.Errorf("metadata field not allowed in non-TABLE queries")
+			}
+			sortNode.Metadata = p.next().Value
+		} else if byField {
+			if p.atWord("ASC") || p.atWord("DESC") {
+				return nil, fmt.Errorf("expected metadata field before %s, got %s", strings.ToUpper(p.peek().Value), strings.ToUpper(p.peek().Value))
+			}
+			return nil, p.expected("a metadata field")
+		}
+
+		if p.atWord("ASC") || p.atWord("DESC") {
+			sortNode.SortDirection = strings.ToUpper(p.next().Value)
+		}
+		sortNodes = append(sortNodes, sortNode)
+
+		if p.atClause() {
+			return sortNodes, nil
+		}
+		if !byField || p.peek().Type != TOKEN_COMMA {
+			candidates := append([]string{"ASC", "DESC"}, clauseKeywords...)
+			if byField {
+				return nil, p.expected("ASC, DESC, a comma or the next clause", candidates...)
+			}
+			return nil, p.expected("ASC, DESC or the next clause", candidates...)
+		}
+		p.next()
+	}
+}
+
+func (p *parser) parseGroupBy(query *QueryNode) error {
+	if p.peek().Type != TOKEN_BY {
+		return p.expected("BY after GROUP", "BY")
+	}
+	p.next()
+
+	if p.peek().Type == TOKEN_NUMBER {
+		query.GroupLimit, _ = strconv.Atoi(p.next().Value)
+		if p.peek().Type != TOKEN_METADATA {
+			return p.expected(fmt.Sprintf("metadata field after GROUP BY %d", query.GroupLimit))
+		}
+	}
+	if p.peek().Type != TOKEN_METADATA {
+		return p.expected("metadata field after GROUP BY")
+	}
+	query.GroupBy = p.next().Value
+	return nil
+}
+
+func (p *parser) parseLimit() error {
+	if p.peek().Type != TOKEN_NUMBER {
+		return p.expected("a number after LIMIT")
+	}
+	limit, err := strconv.Atoi(p.next().Value)
+	if err != nil {
+		return fmt.Errorf("invalid LIMIT value: %w", err)
+	}
+	if limit < 0 {
+		return fmt.Errorf("LIMIT can't be negative, got %d", limit)
+	}
+	p.stage("LIMIT").Limit = limit
+	return nil
+}
+
+func (p *parser) parseOffset() error {
+	if p.peek().Type != TOKEN_NUMBER {
+		return p.expected("a number after OFFSET")
+	}
+	offset, err := strconv.Atoi(p.next().Value)
+	if err != nil {
+		return fmt.Errorf("invalid OFFSET value: %w", err)
+	}
+	if offset < 0 {
+		return fmt.Errorf("OFFSET can't be negative, got %d", offset)
+	}
+	p.stage("OFFSET").Offset = offset
+	return nil
+}
+
+func parseQueryType(value string) (QueryType, bool) {
+	queryType := QueryType(value)
+	return queryType, slices.Contains(queryTypes, queryType)
+}
+
+// describeToken writes a token the way it appears in a query
+func describeToken(token Token) string {
+	switch token.Type {
+	case TOKEN_EOF:
+		return "the end of the query"
+	case TOKEN_STRING:
+		return strconv.Quote(token.Value)
+	case TOKEN_METADATA:
+		return "[" + token.Value + "]"
+	case TOKEN_VARIABLE:
+		return "$" + token.Value
+	}
+	return token.Value
+}
+
+// suggestKeyword returns the candidate a word is most likely a misspelling
+// of, or "" if none of them is close enough
+func suggestKeyword(word string, candidates []string) string {
+	word = strings.ToUpper(word)
+	if alias, ok := keywordAliases[word]; ok && slices.Contains(candidates, alias) {
+		return alias
+	}
+	if slices.Contains(candidates, word) {
+		return ""
+	}
+
+	maxDistance := 1
+	if len(word) > 4 {
+		maxDistance = 2
+	}
+
+	best := ""
+	for _, candidate := range candidates {
+		distance := editDistance(word, candidate)
+		if distance <= maxDistance && distance < len(word) {
+			best = candidate
+			maxDistance = distance - 1
+		}
+	}
+	if best == "IN" {
+		return "IN SECTION"
+	}
+	return best
+}
+
+// editDistance is the number of insertions, deletions, substitutions and
+// swaps of adjacent characters that turn a into b
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
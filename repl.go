@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+const replPrompt = "dynomark> "
+
+// Number of queries kept in the history file
+const replHistorySize = 1000
+
+// Words completed in the REPL, besides the metadata keys of the vault
+var replKeywords = []string{
+	"LIST", "TASK", "PARAGRAPH", "ORDEREDLIST", "UNORDEREDLIST", "FENCEDCODE",
+	"HEADING", "SECTION", "BLOCKQUOTE", "CALLOUT", "LINK", "IMAGE", "MDTABLE",
+	"TABLE", "NO", "ID", "AS", "FROM", "EXCLUDE", "IN", "WHERE", "AND", "OR",
+	"NOT", "IS", "CONTAINS", "CHECKED", "SORT", "ASC", "DESC", "GROUP", "BY",
+	"LIMIT", "OFFSET", "DISTINCT", "DIRECT", "EXPLAIN",
+}
+
+var errInterrupted = errors.New("interrupted")
+
+// repl is an interactive session running queries against a cached vault
+type repl struct {
+	out         io.Writer
+	showAST     bool
+	history     []string
+	historyPath string
+	keys        []string // Metadata keys of the vault, for completion
+}
+
+// runRepl runs the "repl" subcommand
+func runRepl(args []string) error {
+	flags := flag.NewFlagSet("repl", flag.ExitOnError)
+	showAST := flags.Bool("ast", false, "print the parsed query as JSON before showing the results")
+	addQueryFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: dynomark repl [flags]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if err := resolveVaultRoot(rootFlag); err != nil {
+		return err
+	}
+	if err := loadConfig(); err != nil {
+		return err
+	}
+
+	fileCache = make(map[string]*cachedFile)
+	r := &repl{out: os.Stdout, showAST: *showAST}
+	r.loadVault()
+
+	if dir, err := os.UserConfigDir(); err == nil {
+		r.historyPath = filepath.Join(dir, "dynomark", "history")
+		r.history = loadHistory(r.historyPath)
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !isTerminal(fd) {
+		// Queries piped in are run one per line
+		return r.run(bufio.NewScanner(os.Stdin))
+	}
+
+	fmt.Fprintln(r.out, "Type a query, .help for help or .quit to exit.")
+	editor := &lineEditor{
+		in:       bufio.NewReader(os.Stdin),
+		out:      os.Stdout,
+		fd:       fd,
+		prompt:   replPrompt,
+		history:  r.history,
+		complete: r.complete,
+	}
+
+	for {
+		line, err := editor.readLine()
+		if err == errInterrupted {
+			continue
+		}
+		if err == io.EOF {
+			fmt.Fprintln(r.out)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if strings.TrimSpace(line) != "" {
+			r.addHistory(line)
+			editor.history = r.history
+		}
+		if r.execute(line) {
+			return nil
+		}
+	}
+}
+
+// run executes the lines read by scanner until the input ends or .quit
+func (r *repl) run(scanner *bufio.Scanner) error {
+	for scanner.Scan() {
+		if r.execute(scanner.Text()) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// execute runs a query or a REPL command and reports if the session should
+// end
+func (r *repl) execute(line string) bool {
+	line = strings.TrimSpace(line)
+
+	switch line {
+	case "":
+		return false
+	case ".quit", ".exit":
+		return true
+	case ".help":
+		fmt.Fprintln(r.out, `Enter a query to run it against the vault, e.g. TASK FROM "notes/" WHERE NOT CHECKED
+
+Commands:
+  .help    show this help
+  .keys    list the metadata keys of the vault
+  .reload  forget the cached files and read the vault again
+  .quit    exit the REPL (or press Ctrl-D)
+
+Tab completes keywords and [metadata] keys, the arrow keys browse the history.`)
+		return false
+	case ".keys":
+		fmt.Fprintln(r.out, strings.Join(r.keys, "\n"))
+		return false
+	case ".reload":
+		fileCache = make(map[string]*cachedFile)
+		r.loadVault()
+		fmt.Fprintf(r.out, "Reloaded %d files\n", len(fileCache))
+		return false
+	}
+
+	result, err := executeQuery(line, r.showAST)
+	if err != nil {
+		fmt.Fprintf(r.out, "Error: %v\n", err)
+		return false
+	}
+	if result != "" {
+		fmt.Fprintln(r.out, result)
+	}
+	return false
+}
+
+// loadVault reads every file of the vault into the cache and collects their
+// metadata keys
+func (r *repl) loadVault() {
+	keys, err := vaultMetadataKeys()
+	if err != nil {
+		fmt.Fprintf(r.out, "Error: %v\n", err)
+		return
+	}
+	r.keys = keys
+}
+
+// complete returns the completions of the word ending at the end of line,
+// and the index where that word starts
+func (r *repl) complete(line string) ([]string, int) {
+	start := strings.LastIndexFunc(line, unicode.IsSpace) + 1
+	word := line[start:]
+	if word == "" {
+		return nil, start
+	}
+
+	var candidates []string
+	if strings.HasPrefix(word, "[") {
+		prefix := strings.ToLower(word[1:])
+		for _, key := range r.keys {
+			if strings.HasPrefix(key, prefix) {
+				candidates = append(candidates, "["+key+"]")
+			}
+		}
+		return candidates, start
+	}
+
+	for _, keyword := range replKeywords {
+		if strings.HasPrefix(keyword, strings.ToUpper(word)) {
+			candidates = append(candidates, keyword)
+		}
+	}
+	return candidates, start
+}
+
+// addHistory adds a query to the history and appends it to the history file
+func (r *repl) addHistory(line string) {
+	if len(r.history) > 0 && r.history[len(r.history)-1] == line {
+		return
+	}
+	r.history = append(r.history, line)
+	if len(r.history) > replHistorySize {
+		r.history = r.history[len(r.history)-replHistorySize:]
+	}
+
+	if r.historyPath == "" {
+		return
+	}
+	if err := saveHistory(r.historyPath, r.history); err != nil {
+		fmt.Fprintf(r.out, "Error saving the history: %v\n", err)
+		r.historyPath = ""
+	}
+}
+
+// loadHistory reads the queries of a history file, oldest first
+func loadHistory(path string) []string {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var history []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			history = append(history, line)
+		}
+	}
+	if len(history) > replHistorySize {
+		history = history[len(history)-replHistorySize:]
+	}
+	return history
+}
+
+func saveHistory(path string, history []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(strings.Join(history, "\n")+"\n"), 0600)
+}
+
+// lineEditor reads lines from a terminal in raw mode with emacs style
+// editing keys, history browsing and tab completion
+type lineEditor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	fd       int
+	prompt   string
+	history  []string
+	complete func(line string) ([]string, int)
+
+	buffer []rune
+	cursor int
+}
+
+// readLine reads a line, returning errInterrupted on Ctrl-C and io.EOF on
+// Ctrl-D in an empty line
+func (e *lineEditor) readLine() (string, error) {
+	restore, err := makeRaw(e.fd)
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+
+	e.buffer = e.buffer[:0]
+	e.cursor = 0
+	historyIndex := len(e.history)
+	draft := ""
+	lastKeyWasTab := false
+
+	e.refresh()
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		if r != '\t' {
+			lastKeyWasTab = false
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\n")
+			return string(e.buffer), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(e.buffer) == 0 {
+				return "", io.EOF
+			}
+			e.deleteAt(e.cursor)
+		case 1: // Ctrl-A
+			e.cursor = 0
+		case 5: // Ctrl-E
+			e.cursor = len(e.buffer)
+		case 2: // Ctrl-B
+			e.cursor = max(e.cursor-1, 0)
+		case 6: // Ctrl-F
+			e.cursor = min(e.cursor+1, len(e.buffer))
+		case 11: // Ctrl-K
+			e.buffer = e.buffer[:e.cursor]
+		case 21: // Ctrl-U
+			e.buffer = e.buffer[e.cursor:]
+			e.cursor = 0
+		case 23: // Ctrl-W
+			start := e.cursor
+			for start > 0 && unicode.IsSpace(e.buffer[start-1]) {
+				start--
+			}
+			for start > 0 && !unicode.IsSpace(e.buffer[start-1]) {
+				start--
+			}
+			e.buffer = append(e.buffer[:start], e.buffer[e.cursor:]...)
+			e.cursor = start
+		case 12: // Ctrl-L
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case 127, 8: // Backspace
+			if e.cursor > 0 {
+				e.cursor--
+				e.deleteAt(e.cursor)
+			}
+		case '\t':
+			e.completeWord(lastKeyWasTab)
+			lastKeyWasTab = true
+		case 27: // Escape sequences of the arrow, home, end and delete keys
+			switch e.readEscape() {
+			case "[A", "OA":
+				if historyIndex > 0 {
+					if historyIndex == len(e.history) {
+						draft = string(e.buffer)
+					}
+					historyIndex--
+					e.setBuffer(e.history[historyIndex])
+				}
+			case "[B", "OB":
+				if historyIndex < len(e.history) {
+					historyIndex++
+					if historyIndex == len(e.history) {
+						e.setBuffer(draft)
+					} else {
+						e.setBuffer(e.history[historyIndex])
+					}
+				}
+			case "[C", "OC":
+				e.cursor = min(e.cursor+1, len(e.buffer))
+			case "[D", "OD":
+				e.cursor = max(e.cursor-1, 0)
+			case "[H", "OH", "[1~", "[7~":
+				e.cursor = 0
+			case "[F", "OF", "[4~", "[8~":
+				e.cursor = len(e.buffer)
+			case "[3~":
+				e.deleteAt(e.cursor)
+			}
+		default:
+			if unicode.IsPrint(r) {
+				e.insert([]rune{r})
+			}
+		}
+
+		e.refresh()
+	}
+}
+
+// readEscape reads the rest of an escape sequence after the escape character
+func (e *lineEditor) readEscape() string {
+	var sequence []rune
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return string(sequence)
+		}
+		sequence = append(sequence, r)
+		// Sequences end with a letter or a tilde, except for their first
+		// character ("[" or "O")
+		if len(sequence) > 1 && (unicode.IsLetter(r) || r == '~') {
+			return string(sequence)
+		}
+		if len(sequence) == 1 && r != '[' && r != 'O' {
+			return string(sequence)
+		}
+	}
+}
+
+// completeWord completes the word before the cursor. If there are several
+// candidates, their common prefix is inserted, and pressing tab again lists
+// them.
+func (e *lineEditor) completeWord(listCandidates bool) {
+	candidates, start := e.complete(string(e.buffer[:e.cursor]))
+	if len(candidates) == 0 {
+		return
+	}
+
+	// The start is a byte index into the text before the cursor
+	startRune := len([]rune(string(e.buffer[:e.cursor])[:start]))
+	completion := candidates[0]
+	for _, candidate := range candidates[1:] {
+		completion = commonPrefix(completion, candidate)
+	}
+	if len(candidates) == 1 {
+		completion += " "
+	}
+
+	if string(e.buffer[startRune:e.cursor]) != completion && len([]rune(completion)) >= e.cursor-startRune {
+		rest := append([]rune{}, e.buffer[e.cursor:]...)
+		e.buffer = append(e.buffer[:startRune], []rune(completion)...)
+		e.cursor = len(e.buffer)
+		e.buffer = append(e.buffer, rest...)
+		return
+	}
+
+	if listCandidates && len(candidates) > 1 {
+		fmt.Fprintf(e.out, "\n%s\n", strings.Join(candidates, "  "))
+	}
+}
+
+func (e *lineEditor) insert(runes []rune) {
+	e.buffer = append(e.buffer[:e.cursor], append(runes, e.buffer[e.cursor:]...)...)
+	e.cursor += len(runes)
+}
+
+func (e *lineEditor) deleteAt(i int) {
+	if i < len(e.buffer) {
+		e.buffer = append(e.buffer[:i], e.buffer[i+1:]...)
+	}
+}
+
+func (e *lineEditor) setBuffer(line string) {
+	e.buffer = []rune(line)
+	e.cursor = len(e.buffer)
+}
+
+// refresh redraws the prompt and the line, and moves the cursor into place
+func (e *lineEditor) refresh() {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", e.prompt, string(e.buffer))
+	if back := len(e.buffer) - e.cursor; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+// commonPrefix returns the longest common prefix of two strings, ignoring
+// case differences (the first string's case is kept)
+func commonPrefix(a string, b string) string {
+	ar, br := []rune(a), []rune(b)
+	i := 0
+	for i < len(ar) && i < len(br) && unicode.ToLower(ar[i]) == unicode.ToLower(br[i]) {
+		i++
+	}
+	return string(ar[:i])
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// collectMarkdownFiles resolves the paths of a FROM clause into the list of
+// markdown files to query. A path can be a file, a directory (walked
+// recursively) or a glob pattern where "**" matches any number of
+// directories (e.g. "notes/**/2025-*.md"). Files and directories matching
+// one of the exclude patterns are skipped; excluded directories aren't
+// walked at all. A file reached through several paths is only returned the
+// first time.
+func collectMarkdownFiles(from []string, exclude []string) ([]string, error) {
+	var files []string
+	seen := make(map[fileID]bool)
+	add := func(filePath string) {
+		id := fileIdentity(filePath)
+		if !seen[id] {
+			seen[id] = true
+			files = append(files, filePath)
+		}
+	}
+
+	if len(from) == 0 {
+		from = config.From
+	}
+	if len(from) == 0 {
+		return nil, fmt.Errorf("no FROM clause and no default sources in the configuration")
+	}
+	exclude = resolveExcludePatterns(append(slices.Clone(exclude), config.Ignore...))
+
+	for _, source := range from {
+		source = resolvePath(source)
+
+		if hasGlobMeta(source) {
+			if err := checkPathSafety(globRoot(source)); err != nil {
+				return nil, err
+			}
+			matches, err := globMarkdownFiles(source, exclude)
+			if err != nil {
+				return nil, err
+			}
+			for _, match := range matches {
+				add(match)
+			}
+			continue
+		}
+
+		if err := checkPathSafety(source); err != nil {
+			return nil, err
+		}
+
+		fileInfo, err := os.Stat(source)
+		if err != nil {
+			return nil, err
+		}
+
+		if !fileInfo.IsDir() {
+			if !isExcluded(source, false, exclude) {
+				add(source)
+			}
+			continue
+		}
+
+		err = walkMarkdownFiles(source, exclude, add)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// fileID identifies a file on disk, see fileIdentity
+type fileID struct {
+	Device uint64
+	Inode  uint64
+	Path   string // Canonical path, when the inode isn't known
+}
+
+// canonicalPath returns the absolute path of a file with its symlinks
+// resolved, or the cleaned path if it can't be resolved
+func canonicalPath(p string) string {
+	absPath, err := filepath.Abs(p)
+	if err != nil {
+		return filepath.Clean(p)
+	}
+	if realPath, err := filepath.EvalSymlinks(absPath); err == nil {
+		return realPath
+	}
+	return absPath
+}
+
+// resolveExcludePatterns resolves the exclude patterns that are paths (the
+// ones containing a slash) against the vault root, like the FROM paths
+func resolveExcludePatterns(exclude []string) []string {
+	resolved := make([]string, len(exclude))
+	for i, pattern := range exclude {
+		trimmed := strings.TrimSuffix(filepath.ToSlash(pattern), "/")
+		if !strings.Contains(trimmed, "/") {
+			resolved[i] = pattern
+			continue
+		}
+		resolved[i] = resolvePath(trimmed)
+		if trimmed != filepath.ToSlash(pattern) {
+			resolved[i] += "/"
+		}
+	}
+	return resolved
+}
+
+// expandPath expands a leading "~" to the home directory and environment
+// variables in a path
+func expandPath(p string) string {
+	if strings.HasPrefix(p, "~") {
+		p = filepath.Join(os.Getenv("HOME"), p[1:])
+	}
+	return os.ExpandEnv(p)
+}
+
+// walkMarkdownFiles calls fn for every markdown file under root, pruning the
+// excluded directories and the ones ignored by .gitignore and .dynomarkignore
+// files (unless -no-ignore is set)
+func walkMarkdownFiles(root string, exclude []string, fn func(string)) error {
+	var ignores *ignoreMatcher
+	if !noIgnoreFlag {
+		ignores = newIgnoreMatcher(root)
+	}
+
+	return filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			if filePath == root {
+				return nil
+			}
+			if isExcluded(filePath, true, exclude) || (ignores != nil && ignores.isIgnored(filePath, true)) {
+				return filepath.SkipDir
+			}
+			if ignores != nil {
+				ignores.load(filePath)
+			}
+			return nil
+		}
+
+		if !isMarkdownFile(filePath) || isExcluded(filePath, false, exclude) {
+			return nil
+		}
+		if ignores != nil && ignores.isIgnored(filePath, false) {
+			return nil
+		}
+		// Symlinked files pointing outside of the vault are skipped
+		if checkPathSafety(filePath) != nil {
+			return nil
+		}
+
+		fn(filePath)
+		return nil
+	})
+}
+
+// globMarkdownFiles walks the directory a glob pattern starts in and returns
+// the markdown files matching the pattern
+func globMarkdownFiles(pattern string, exclude []string) ([]string, error) {
+	root := globRoot(pattern)
+	if _, err := os.Stat(root); err != nil {
+		// Nothing can match in a directory that doesn't exist
+		return nil, nil
+	}
+
+	var files []string
+	err := walkMarkdownFiles(root, exclude, func(filePath string) {
+		if matchGlob(filepath.ToSlash(pattern), filepath.ToSlash(filePath)) {
+			files = append(files, filePath)
+		}
+	})
+	return files, err
+}
+
+func hasGlobMeta(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// globRoot returns the directory part of a glob pattern that doesn't contain
+// any wildcards (e.g. "notes/2025" for "notes/2025/**/*.md")
+func globRoot(pattern string) string {
+	parts := strings.Split(filepath.ToSlash(pattern), "/")
+	var root []string
+	for _, part := range parts[:len(parts)-1] {
+		if hasGlobMeta(part) {
+			break
+		}
+		root = append(root, part)
+	}
+
+	if len(root) == 0 {
+		return "."
+	}
+	if len(root) == 1 && root[0] == "" {
+		return "/"
+	}
+	return filepath.FromSlash(strings.Join(root, "/"))
+}
+
+// matchGlob matches a slash separated path against a glob pattern. Unlike
+// path.Match, "**" matches any number of directories (including none).
+func matchGlob(pattern string, name string) bool {
+	return matchGlobParts(splitPath(pattern), splitPath(name))
+}
+
+func matchGlobParts(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchGlobParts(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+
+	return len(name) == 0
+}
+
+// splitPath splits a cleaned, slash separated path into its elements
+func splitPath(p string) []string {
+	p = path.Clean(p)
+	if p == "." {
+		return nil
+	}
+	return strings.Split(strings.TrimPrefix(p, "/"), "/")
+}
+
+// isExcluded checks a file or directory against the exclude patterns. A
+// pattern without a slash (e.g. "templates" or "*.draft.md") matches a file
+// or directory with that name anywhere, a pattern ending with a slash only
+// matches directories and any other pattern is matched against the whole
+// path. Everything inside an excluded directory is excluded too.
+func isExcluded(filePath string, isDir bool, exclude []string) bool {
+	name := filepath.ToSlash(filepath.Clean(filePath))
+
+	for _, pattern := range exclude {
+		pattern = filepath.ToSlash(expandPath(pattern))
+		dirOnly := strings.HasSuffix(pattern, "/")
+		pattern = path.Clean(pattern)
+
+		if !strings.Contains(pattern, "/") {
+			parts := splitPath(name)
+			for i, part := range parts {
+				// The last element is the file itself
+				if dirOnly && i == len(parts)-1 && !isDir {
+					continue
+				}
+				if ok, _ := path.Match(pattern, part); ok {
+					return true
+				}
+			}
+			continue
+		}
+
+		// Match the path itself or one of its parent directories
+		parts := splitPath(name)
+		for i := len(parts); i > 0; i-- {
+			if dirOnly && i == len(parts) && !isDir {
+				continue
+			}
+			if matchGlob(pattern, strings.Join(parts[:i], "/")) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
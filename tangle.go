@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// tangleTarget is a file written by the tangle command, built from all the
+// code blocks that name it in their "file" attribute
+type tangleTarget struct {
+	Path    string
+	Sources []string // Markdown files the blocks came from
+	Blocks  []string
+}
+
+// runTangle implements the "dynomark tangle" command, which writes code
+// blocks to the files named in their info string (```go file=cmd/main.go)
+func runTangle(args []string) error {
+	flags := flag.NewFlagSet("tangle", flag.ExitOnError)
+	dir := flags.String("dir", "", "write files relative to this directory instead of the markdown file's directory")
+	dryRun := flags.Bool("dry-run", false, "only print the files that would be written")
+	addSourceFlags(flags)
+	var query string
+	flags.StringVar(&query, "query", "", "FENCEDCODE query selecting the blocks to tangle")
+	flags.StringVar(&query, "q", "", "FENCEDCODE query selecting the blocks to tangle (shorthand)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: dynomark tangle [flags] [-q query | path...]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if err := resolveVaultRoot(rootFlag); err != nil {
+		return err
+	}
+	if err := loadConfig(); err != nil {
+		return err
+	}
+
+	ast := &QueryNode{Type: FENCEDCODE, From: flags.Args(), Limit: -1}
+	if query != "" {
+		tokens, err := bindVariables(Lex(query))
+		if err != nil {
+			return fmt.Errorf("failed to parse query: %w", err)
+		}
+		ast, err = Parse(tokens)
+		if err != nil {
+			return fmt.Errorf("failed to parse query: %w", err)
+		}
+		if ast.Type != FENCEDCODE {
+			return fmt.Errorf("tangle only works with FENCEDCODE queries, got %s", ast.Type)
+		}
+		if ast.GroupBy != "" {
+			return fmt.Errorf("tangle can't write grouped blocks, remove GROUP BY [%s]", ast.GroupBy)
+		}
+	}
+
+	if len(ast.From) == 0 {
+		flags.Usage()
+		return fmt.Errorf("no markdown files to tangle")
+	}
+
+	report, err := tangleFiles(ast, *dir, *dryRun)
+	fmt.Print(report)
+	return err
+}
+
+// tangleFiles writes the code blocks matched by the query to their files and
+// returns a report of what was written. Files that several markdown files
+// want to write to are conflicts and are not written at all.
+func tangleFiles(ast *QueryNode, dir string, dryRun bool) (string, error) {
+	content, metadataList, err := parseMarkdownFiles(ast.From, ast)
+	if err != nil {
+		return "", err
+	}
+
+	content, metadataList = filterContent(content, metadataList, ast.Where)
+	if ast.Distinct {
+		content, metadataList = distinctContent(content, metadataList, ast.DistinctField)
+	}
+	// Blocks are written in the order the SORT stages leave them in
+	content, metadataList, _ = applyStages(content, metadataList, ast)
+
+	targets, conflicts := collectTangleTargets(content, metadataList, dir)
+
+	var report strings.Builder
+	for _, target := range targets {
+		if len(target.Sources) > 1 {
+			conflicts = append(conflicts, fmt.Sprintf("%s is written by multiple files: %s",
+				target.Path, strings.Join(target.Sources, ", ")))
+			continue
+		}
+
+		data := []byte(strings.Join(target.Blocks, "\n") + "\n")
+		status := "wrote"
+		if existing, err := os.ReadFile(target.Path); err == nil && bytes.Equal(existing, data) {
+			status = "unchanged"
+		} else if dryRun {
+			status = "would write"
+		} else {
+			if err := os.MkdirAll(filepath.Dir(target.Path), 0755); err != nil {
+				return report.String(), err
+			}
+			if err := os.WriteFile(target.Path, data, 0644); err != nil {
+				return report.String(), err
+			}
+		}
+
+		report.WriteString(fmt.Sprintf("%s %s (%d blocks from %s)\n", status, target.Path, len(target.Blocks), target.Sources[0]))
+	}
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return report.String(), fmt.Errorf("found %d conflicts:\n  %s", len(conflicts), strings.Join(conflicts, "\n  "))
+	}
+
+	return report.String(), nil
+}
+
+// collectTangleTargets groups the code blocks by the file they should be
+// written to, keeping the blocks in document order
+func collectTangleTargets(content []string, metadataList []Metadata, dir string) ([]*tangleTarget, []string) {
+	var targets []*tangleTarget
+	var conflicts []string
+	targetsByPath := make(map[string]*tangleTarget)
+
+	for i, code := range content {
+		file, ok := metadataList[i]["attr.file"].(string)
+		if !ok || file == "" || file == "true" {
+			continue
+		}
+		source := fmt.Sprintf("%v", metadataList[i]["file.path"])
+
+		base := dir
+		if base == "" {
+			base = filepath.Dir(resolvePath(source))
+		}
+
+		if filepath.IsAbs(file) {
+			conflicts = append(conflicts, fmt.Sprintf("%s: absolute path %s is not allowed", source, file))
+			continue
+		}
+		path := filepath.Join(base, file)
+		if rel, err := filepath.Rel(base, path); err != nil || isOutside(rel) {
+			conflicts = append(conflicts, fmt.Sprintf("%s: %s is outside of %s", source, file, base))
+			continue
+		}
+
+		target, ok := targetsByPath[path]
+		if !ok {
+			target = &tangleTarget{Path: path}
+			targetsByPath[path] = target
+			targets = append(targets, target)
+		}
+		if !slices.Contains(target.Sources, source) {
+			target.Sources = append(target.Sources, source)
+		}
+		target.Blocks = append(target.Blocks, code)
+	}
+
+	return targets, conflicts
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Values of the $variables bound with -var
+var queryVariables = make(map[string]string)
+
+// The note a query belongs to, set with -context-file. Editor plugins pass
+// the file of the current buffer.
+var contextFileFlag string
+
+// parseVariableFlag binds a variable from a "name=value" -var flag
+func parseVariableFlag(binding string) error {
+	name, value, ok := strings.Cut(binding, "=")
+	name = strings.TrimPrefix(strings.TrimSpace(name), "$")
+	if !ok || !isVariable("$"+name) {
+		return fmt.Errorf("expected name=value, got %s", binding)
+	}
+	queryVariables[name] = value
+	return nil
+}
+
+// isVariable checks if an unquoted word is a $variable. Names start with a
+// letter or an underscore and can contain dots and dashes (e.g. $this.file).
+func isVariable(word string) bool {
+	if len(word) < 2 || word[0] != '$' {
+		return false
+	}
+	for i, r := range word[1:] {
+		if r == '_' || unicode.IsLetter(r) || (i > 0 && (unicode.IsDigit(r) || r == '.' || r == '-')) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// bindVariables replaces the variable tokens with the value bound to them.
+// Values become a single string token (or a number after LIMIT, OFFSET and
+// GROUP BY), so quotes or keywords in a value can never change the query
+// itself.
+func bindVariables(tokens []Token) ([]Token, error) {
+	bound := make([]Token, len(tokens))
+	for i, token := range tokens {
+		if token.Type != TOKEN_VARIABLE {
+			bound[i] = token
+			continue
+		}
+
+		value, ok := lookupVariable(token.Value)
+		if !ok && contextFileFlag != "" && strings.HasPrefix(token.Value, "this.") {
+			if _, err := contextMetadata(); err != nil {
+				return nil, fmt.Errorf("failed to read the context file: %w", err)
+			}
+			return nil, fmt.Errorf("%s has no %s field", contextFileFlag, strings.TrimPrefix(token.Value, "this."))
+		}
+		if !ok {
+			return nil, fmt.Errorf("unbound variable $%s", token.Value)
+		}
+
+		bound[i] = Token{Type: TOKEN_STRING, Value: value}
+		if i > 0 && (tokens[i-1].Value == "LIMIT" || tokens[i-1].Value == "OFFSET" || tokens[i-1].Type == TOKEN_BY) && isNumber(value) {
+			bound[i].Type = TOKEN_NUMBER
+		}
+	}
+	return bound, nil
+}
+
+// lookupVariable returns the value of a variable from the -var flags, the
+// DYNOMARK_VAR_<NAME> environment variables or the built-in variables, in
+// that order
+func lookupVariable(name string) (string, bool) {
+	if value, ok := queryVariables[name]; ok {
+		return value, true
+	}
+	if value, ok := os.LookupEnv(variableEnvName(name)); ok {
+		return value, true
+	}
+	return builtinVariable(name)
+}
+
+// variableEnvName returns the environment variable of a query variable, e.g.
+// DYNOMARK_VAR_THIS_FILE for $this.file
+func variableEnvName(name string) string {
+	return "DYNOMARK_VAR_" + strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' {
+			return '_'
+		}
+		return unicode.ToUpper(r)
+	}, name)
+}
+
+// builtinVariable returns the value of $today and the $this.* variables
+// describing the -context-file. Any other $this.<field> is a metadata field
+// of that file.
+func builtinVariable(name string) (string, bool) {
+	if name == "today" {
+		return time.Now().Format(config.DateFormat), true
+	}
+
+	if contextFileFlag == "" {
+		return "", false
+	}
+	// The path is relative to the vault root, like file.path
+	path := relativePath(expandPath(contextFileFlag))
+
+	switch name {
+	case "this.file":
+		return path, true
+	case "this.folder":
+		return filepath.Dir(path), true
+	case "this.name":
+		return filepath.Base(path), true
+	}
+
+	field, ok := strings.CutPrefix(name, "this.")
+	if !ok {
+		return "", false
+	}
+	metadata, err := contextMetadata()
+	if err != nil {
+		return "", false
+	}
+	value, ok := metadata[strings.ToLower(field)]
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%v", value), true
+}
+
+// The metadata of the context file, read once per file
+var contextCache struct {
+	path     string
+	metadata Metadata
+}
+
+// contextMetadata returns the metadata of the -context-file, including its
+// frontmatter, inline fields and file.* fields
+func contextMetadata() (Metadata, error) {
+	path := expandPath(contextFileFlag)
+	if contextCache.path == path && contextCache.metadata != nil {
+		return contextCache.metadata, nil
+	}
+
+	_, metadata, err := parseMarkdownContent(path, &QueryNode{Type: LIST})
+	if err != nil {
+		return nil, err
+	}
+	contextCache.path = path
+	contextCache.metadata = metadata
+	return metadata, nil
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Files and directories marking the root of a vault. The vault root is the
+// nearest parent directory of the working directory containing one of them.
+var vaultMarkers = []string{".dynomarkroot", ".dynomark.toml", ".obsidian"}
+
+// Root directory of the vault given with the -root flag
+var rootFlag string
+
+// The root of the vault that FROM paths and file links are resolved against,
+// either set with -root or discovered from a marker file. Empty if there is
+// no vault, in which case paths are relative to the working directory.
+var vaultRoot string
+
+// Refuse paths that escape the vault root through ".." or symlinks, set with
+// the -strict-paths flag
+var strictPathsFlag bool
+
+// findVaultRoot returns the nearest directory at or above start that contains
+// one of the vault markers, or an empty string if there is none
+func findVaultRoot(start string) string {
+	dir, err := filepath.Abs(start)
+	if err != nil {
+		return ""
+	}
+
+	for {
+		for _, marker := range vaultMarkers {
+			if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+				return dir
+			}
+		}
+		if dir == filepath.Dir(dir) {
+			return ""
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// resolveVaultRoot sets the vault root from the -root flag, or discovers it
+// from the working directory if the flag is empty
+func resolveVaultRoot(root string) error {
+	if root == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		vaultRoot = findVaultRoot(cwd)
+		return nil
+	}
+
+	root, err := filepath.Abs(expandPath(root))
+	if err != nil {
+		return err
+	}
+	fileInfo, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !fileInfo.IsDir() {
+		return fmt.Errorf("vault root %s is not a directory", root)
+	}
+
+	vaultRoot = root
+	return nil
+}
+
+// resolvePath resolves a relative path against the vault root
+func resolvePath(p string) string {
+	p = expandPath(p)
+	if vaultRoot == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(vaultRoot, p)
+}
+
+// relativePath returns a path relative to the vault root. Paths outside of
+// the vault and paths without a vault root are returned unchanged.
+func relativePath(p string) string {
+	if vaultRoot == "" {
+		return p
+	}
+	absPath, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	rel, err := filepath.Rel(vaultRoot, absPath)
+	if err != nil || isOutside(rel) {
+		return p
+	}
+	return rel
+}
+
+// checkPathSafety returns an error in strict mode if a path points outside of
+// the vault root, either directly through ".." or by following a symlink.
+// The working directory is the root if there is no vault.
+func checkPathSafety(p string) error {
+	if !strictPathsFlag {
+		return nil
+	}
+
+	root := vaultRoot
+	if root == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		root = cwd
+	}
+
+	absPath, err := filepath.Abs(p)
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(root, absPath); err != nil || isOutside(rel) {
+		return fmt.Errorf("path %s is outside of the vault root %s", p, root)
+	}
+
+	// Both sides need their symlinks resolved, the root itself may be a link
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	realPath, err := filepath.EvalSymlinks(absPath)
+	if err != nil {
+		// Paths that don't exist are reported by whoever opens them
+		return nil
+	}
+	if rel, err := filepath.Rel(realRoot, realPath); err != nil || isOutside(rel) {
+		return fmt.Errorf("path %s links outside of the vault root %s", p, root)
+	}
+
+	return nil
+}
+
+// isOutside checks if a relative path starts by leaving its base directory
+func isOutside(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}